@@ -0,0 +1,61 @@
+package siwe
+
+import (
+	"crypto/ecdsa"
+	"runtime"
+	"sync"
+)
+
+// VerifyItem is a message and its signature to be verified by VerifyBatch.
+type VerifyItem struct {
+	Message   *Message
+	Signature string
+}
+
+// VerifyResult holds the outcome of verifying a single VerifyItem.
+type VerifyResult struct {
+	// PublicKey is the signer's key, it is nil when Err is set or the
+	// signature was accepted by a contract wallet.
+	PublicKey *ecdsa.PublicKey
+	Err       error
+}
+
+// VerifyBatch verifies every item against the same options using a bounded pool
+// of workers. Results are returned in input order, a failing item doesn't stop the others.
+func VerifyBatch(items []VerifyItem, opts VerifyOptions) []VerifyResult {
+	results := make([]VerifyResult, len(items))
+
+	workers := runtime.NumCPU()
+	if workers > len(items) {
+		workers = len(items)
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i] = verifyItem(items[i], opts)
+			}
+		}()
+	}
+
+	for i := range items {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	return results
+}
+
+func verifyItem(item VerifyItem, opts VerifyOptions) VerifyResult {
+	if item.Message == nil {
+		return VerifyResult{Err: &MalformedMessage{"Message cannot be empty"}}
+	}
+
+	publicKey, err := item.Message.VerifyWithOptions(item.Signature, opts)
+	return VerifyResult{PublicKey: publicKey, Err: err}
+}
@@ -0,0 +1,107 @@
+package siwe
+
+import (
+	"net/url"
+	"time"
+)
+
+// MessageBuilder constructs a Message through typed, chainable setters.
+type MessageBuilder struct {
+	domain  string
+	address string
+	uri     string
+	nonce   string
+	options map[string]interface{}
+}
+
+// NewMessageBuilder creates an empty MessageBuilder.
+func NewMessageBuilder() *MessageBuilder {
+	return &MessageBuilder{options: make(map[string]interface{})}
+}
+
+// WithScheme sets the scheme of the origin requesting the sign-in, e.g. "https".
+func (b *MessageBuilder) WithScheme(scheme string) *MessageBuilder {
+	b.options["scheme"] = scheme
+	return b
+}
+
+// WithDomain sets the RFC 3986 authority requesting the sign-in.
+func (b *MessageBuilder) WithDomain(domain string) *MessageBuilder {
+	b.domain = domain
+	return b
+}
+
+// WithAddress sets the Ethereum address performing the sign-in, lowercase or in EIP-55 form.
+func (b *MessageBuilder) WithAddress(address string) *MessageBuilder {
+	b.address = address
+	return b
+}
+
+// WithURI sets the RFC 3986 URI referring to the subject of the sign-in.
+func (b *MessageBuilder) WithURI(uri string) *MessageBuilder {
+	b.uri = uri
+	return b
+}
+
+// WithNonce sets the nonce, at least 8 alphanumeric characters issued by the server.
+func (b *MessageBuilder) WithNonce(nonce string) *MessageBuilder {
+	b.nonce = nonce
+	return b
+}
+
+// WithStatement sets the single-line statement the user is asked to sign.
+func (b *MessageBuilder) WithStatement(statement string) *MessageBuilder {
+	b.options["statement"] = statement
+	return b
+}
+
+// WithChainID sets the EIP-155 chain ID, DefaultChainID is used otherwise.
+func (b *MessageBuilder) WithChainID(chainID int) *MessageBuilder {
+	b.options["chainId"] = chainID
+	return b
+}
+
+// WithIssuedAt sets the issuance time, the current time is used otherwise.
+func (b *MessageBuilder) WithIssuedAt(issuedAt time.Time) *MessageBuilder {
+	b.options["issuedAt"] = issuedAt
+	return b
+}
+
+// WithExpirationTime sets the time after which the message is no longer valid.
+func (b *MessageBuilder) WithExpirationTime(expirationTime time.Time) *MessageBuilder {
+	b.options["expirationTime"] = expirationTime
+	return b
+}
+
+// WithNotBefore sets the time before which the message is not yet valid.
+func (b *MessageBuilder) WithNotBefore(notBefore time.Time) *MessageBuilder {
+	b.options["notBefore"] = notBefore
+	return b
+}
+
+// WithRequestID sets an identifier the server may use to refer to the sign-in.
+func (b *MessageBuilder) WithRequestID(requestID string) *MessageBuilder {
+	b.options["requestId"] = requestID
+	return b
+}
+
+// WithResources sets the resources the user wishes to have resolved as part of the sign-in.
+func (b *MessageBuilder) WithResources(resources ...url.URL) *MessageBuilder {
+	b.options["resources"] = resources
+	return b
+}
+
+// Build validates the configured fields and returns the resulting Message,
+// which is guaranteed to serialize to a parseable EIP-4361 message.
+func (b *MessageBuilder) Build() (*Message, error) {
+	message, err := InitMessage(b.domain, b.address, b.uri, b.nonce, b.options)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := message.Validate(); err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
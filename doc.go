@@ -0,0 +1,10 @@
+// Package siwe implements EIP-4361 Sign-In with Ethereum messages: parsing,
+// preparing, signing and verifying them.
+//
+// All package functions are safe for concurrent use. A Message is only modified
+// by its Set methods, otherwise it may be shared between goroutines. The
+// package variables DefaultChainID, SupportedVersions and DefaultParseLimits
+// are read without synchronization, so they must only be set during
+// initialization. The default URI scheme allowlist of VerifyOptions can't be
+// modified, WebURISchemes returns a copy.
+package siwe
@@ -0,0 +1,61 @@
+package siwe
+
+import (
+	"bytes"
+	"context"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+)
+
+// Ref: https://eips.ethereum.org/EIPS/eip-1271
+const _EIP1271_ABI = `[{"inputs":[{"name":"hash","type":"bytes32"},{"name":"signature","type":"bytes"}],"name":"isValidSignature","outputs":[{"name":"magicValue","type":"bytes4"}],"stateMutability":"view","type":"function"}]`
+
+var _EIP1271_MAGIC_VALUE = []byte{0x16, 0x26, 0xba, 0x7e}
+
+var eip1271ABI = func() abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(_EIP1271_ABI))
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}()
+
+// VerifyEIP1271 validates the signature of a smart contract wallet by calling
+// isValidSignature on the message address.
+func (m *Message) VerifyEIP1271(ctx context.Context, client bind.ContractCaller, signature []byte) error {
+	input, err := eip1271ABI.Pack("isValidSignature", m.eip191Hash(), signature)
+	if err != nil {
+		return &InvalidSignature{"Failed to encode isValidSignature call"}
+	}
+
+	address := m.address
+	output, err := client.CallContract(ctx, ethereum.CallMsg{To: &address, Data: input}, nil)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return &InvalidSignature{"Failed to call isValidSignature on contract wallet"}
+	}
+
+	if len(output) < len(_EIP1271_MAGIC_VALUE) || !bytes.Equal(output[:len(_EIP1271_MAGIC_VALUE)], _EIP1271_MAGIC_VALUE) {
+		return &InvalidSignature{"Contract wallet rejected signature"}
+	}
+
+	return nil
+}
+
+// isContract reports whether the message address holds contract code, failing
+// if the code can't be fetched rather than treating the address as an account.
+func (m *Message) isContract(ctx context.Context, client bind.ContractCaller) (bool, error) {
+	code, err := client.CodeAt(ctx, m.address, nil)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return false, ctxErr
+		}
+		return false, err
+	}
+	return len(code) > 0, nil
+}
@@ -0,0 +1,85 @@
+package siwe
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// EIP1271CacheKey identifies an EIP-1271 signature check.
+type EIP1271CacheKey struct {
+	Signer    common.Address
+	Digest    common.Hash
+	Signature string
+}
+
+// EIP1271Cache remembers signatures accepted by contract wallets, so repeated
+// verifications can skip the isValidSignature call. Rejections aren't cached.
+type EIP1271Cache interface {
+	// Valid reports whether key was stored and is still fresh.
+	Valid(key EIP1271CacheKey) bool
+	// Store records key as accepted by the contract wallet.
+	Store(key EIP1271CacheKey)
+}
+
+// MemoryEIP1271Cache is a goroutine-safe in-memory EIP1271Cache whose entries expire after a TTL.
+type MemoryEIP1271Cache struct {
+	ttl       time.Duration
+	now       func() time.Time
+	mu        sync.Mutex
+	entries   map[EIP1271CacheKey]time.Time
+	nextSweep time.Time
+}
+
+// NewMemoryEIP1271Cache creates a MemoryEIP1271Cache whose entries expire after ttl.
+func NewMemoryEIP1271Cache(ttl time.Duration) *MemoryEIP1271Cache {
+	return &MemoryEIP1271Cache{
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[EIP1271CacheKey]time.Time),
+	}
+}
+
+// Valid reports whether key was stored less than the TTL ago.
+func (c *MemoryEIP1271Cache) Valid(key EIP1271CacheKey) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expiresAt, ok := c.entries[key]
+	if !ok {
+		return false
+	}
+
+	if !c.now().Before(expiresAt) {
+		delete(c.entries, key)
+		return false
+	}
+
+	return true
+}
+
+// Store records key until the TTL elapses.
+func (c *MemoryEIP1271Cache) Store(key EIP1271CacheKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	c.evict(now)
+	c.entries[key] = now.Add(c.ttl)
+}
+
+// evict drops expired entries, scanning the cache at most once per TTL so
+// storing stays cheap. Valid checks expiry itself.
+func (c *MemoryEIP1271Cache) evict(now time.Time) {
+	if now.Before(c.nextSweep) {
+		return
+	}
+	c.nextSweep = now.Add(c.ttl)
+
+	for key, expiresAt := range c.entries {
+		if !now.Before(expiresAt) {
+			delete(c.entries, key)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package siwe
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"strconv"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
+
+var _EIP712_TYPES = apitypes.Types{
+	"EIP712Domain": {
+		{Name: "name", Type: "string"},
+		{Name: "version", Type: "string"},
+		{Name: "chainId", Type: "uint256"},
+	},
+	"Message": {
+		{Name: "scheme", Type: "string"},
+		{Name: "domain", Type: "string"},
+		{Name: "address", Type: "address"},
+		{Name: "statement", Type: "string"},
+		{Name: "uri", Type: "string"},
+		{Name: "version", Type: "string"},
+		{Name: "chainId", Type: "uint256"},
+		{Name: "nonce", Type: "string"},
+		{Name: "issuedAt", Type: "string"},
+		{Name: "expirationTime", Type: "string"},
+		{Name: "notBefore", Type: "string"},
+		{Name: "requestId", Type: "string"},
+		{Name: "resources", Type: "string[]"},
+	},
+}
+
+// TypedData returns the EIP-712 structured representation of the message, as
+// signed by wallets using eth_signTypedData instead of personal_sign. Absent
+// optional fields are encoded as empty strings.
+func (m *Message) TypedData() apitypes.TypedData {
+	dto := m.ToDTO()
+
+	resources := make([]interface{}, len(dto.Resources))
+	for i, resource := range dto.Resources {
+		resources[i] = resource
+	}
+
+	chainID := math.HexOrDecimal256(*big.NewInt(int64(m.chainID)))
+
+	return apitypes.TypedData{
+		Types:       _EIP712_TYPES,
+		PrimaryType: "Message",
+		Domain: apitypes.TypedDataDomain{
+			Name:    m.domain,
+			Version: m.version,
+			ChainId: &chainID,
+		},
+		Message: apitypes.TypedDataMessage{
+			"scheme":         dto.Scheme,
+			"domain":         dto.Domain,
+			"address":        dto.Address,
+			"statement":      dto.Statement,
+			"uri":            dto.URI,
+			"version":        dto.Version,
+			"chainId":        strconv.Itoa(m.chainID),
+			"nonce":          dto.Nonce,
+			"issuedAt":       dto.IssuedAt,
+			"expirationTime": dto.ExpirationTime,
+			"notBefore":      dto.NotBefore,
+			"requestId":      dto.RequestID,
+			"resources":      resources,
+		},
+	}
+}
+
+func (m *Message) eip712Hash() (common.Hash, error) {
+	hash, _, err := apitypes.TypedDataAndHash(m.TypedData())
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return common.BytesToHash(hash), nil
+}
+
+// VerifyEIP712 validates the integrity of the object by matching a signature
+// over its EIP-712 typed data representation.
+func (m *Message) VerifyEIP712(signature string) (*ecdsa.PublicKey, error) {
+	hash, err := m.eip712Hash()
+	if err != nil {
+		return nil, &MalformedMessage{"Message could not be encoded as typed data"}
+	}
+
+	pkey, _, err := m.verifySigner(hash, signature)
+	return pkey, err
+}
@@ -0,0 +1,19 @@
+package siwe
+
+import (
+	"context"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// ENSResolver performs reverse ENS resolution of an address.
+type ENSResolver interface {
+	// LookupAddress returns the primary ENS name of address.
+	LookupAddress(ctx context.Context, address common.Address) (string, error)
+}
+
+// ResolveENS returns the ENS name of the message address for display purposes,
+// it plays no part in verification.
+func (m *Message) ResolveENS(ctx context.Context, resolver ENSResolver) (string, error) {
+	return resolver.LookupAddress(ctx, m.address)
+}
@@ -1,12 +1,42 @@
 package siwe
 
 import (
+	"errors"
 	"fmt"
 )
 
+// Sentinel errors matching each error category with errors.Is.
+var (
+	ErrExpiredMessage     = errors.New("expired message")
+	ErrInvalidMessage     = errors.New("invalid message")
+	ErrInvalidSignature   = errors.New("invalid signature")
+	ErrInvalidVersion     = errors.New("invalid version")
+	ErrNotYetValidMessage = errors.New("not yet valid message")
+	ErrDomainMismatch     = errors.New("domain mismatch")
+	ErrNonceMismatch      = errors.New("nonce mismatch")
+)
+
 type ExpiredMessage struct{ string }
 type InvalidMessage struct{ string }
 type InvalidSignature struct{ string }
+type InvalidVersion struct{ string }
+type NotYetValidMessage struct{ string }
+type DomainMismatch struct{ string }
+type NonceMismatch struct{ string }
+
+// MalformedMessage reports input that isn't a well-formed EIP-4361 message: it
+// doesn't match the grammar, or a field is missing, of the wrong type or in an
+// invalid format. InvalidMessage is kept for well-formed messages rejected by
+// a caller's policy, such as VerifyOptions or ParseLimits. MalformedMessage is
+// also matched by errors.As with an *InvalidMessage target, which it was
+// reported as before.
+type MalformedMessage struct{ string }
+
+// ParseError is returned when a message doesn't match the EIP-4361 grammar,
+// Section holds the name of the first field that could not be located.
+type ParseError struct {
+	Section string
+}
 
 func (m *ExpiredMessage) Error() string {
 	return fmt.Sprintf("Expired Message: %s", m.string)
@@ -19,3 +49,80 @@ func (m *InvalidMessage) Error() string {
 func (m *InvalidSignature) Error() string {
 	return fmt.Sprintf("Invalid Signature: %s", m.string)
 }
+
+func (m *InvalidVersion) Error() string {
+	return fmt.Sprintf("Invalid Version: %s", m.string)
+}
+
+func (m *NotYetValidMessage) Error() string {
+	return fmt.Sprintf("Not Yet Valid Message: %s", m.string)
+}
+
+func (m *DomainMismatch) Error() string {
+	return fmt.Sprintf("Domain Mismatch: %s", m.string)
+}
+
+func (m *NonceMismatch) Error() string {
+	return fmt.Sprintf("Nonce Mismatch: %s", m.string)
+}
+
+func (m *MalformedMessage) Error() string {
+	return fmt.Sprintf("Invalid Message: %s", m.string)
+}
+
+func (m *ParseError) detail() string {
+	if m.Section == "" {
+		return "Message could not be parsed"
+	}
+	return fmt.Sprintf("Message could not be parsed at `%s`", m.Section)
+}
+
+func (m *ParseError) Error() string {
+	return fmt.Sprintf("Invalid Message: %s", m.detail())
+}
+
+func (m *ExpiredMessage) Unwrap() error {
+	return ErrExpiredMessage
+}
+
+func (m *InvalidMessage) Unwrap() error {
+	return ErrInvalidMessage
+}
+
+func (m *InvalidSignature) Unwrap() error {
+	return ErrInvalidSignature
+}
+
+func (m *InvalidVersion) Unwrap() error {
+	return ErrInvalidVersion
+}
+
+func (m *NotYetValidMessage) Unwrap() error {
+	return ErrNotYetValidMessage
+}
+
+func (m *DomainMismatch) Unwrap() error {
+	return ErrDomainMismatch
+}
+
+func (m *NonceMismatch) Unwrap() error {
+	return ErrNonceMismatch
+}
+
+func (m *MalformedMessage) Unwrap() error {
+	return ErrInvalidMessage
+}
+
+// As lets errors.As match a MalformedMessage with an *InvalidMessage target.
+func (m *MalformedMessage) As(target interface{}) bool {
+	if invalid, ok := target.(**InvalidMessage); ok {
+		*invalid = &InvalidMessage{m.string}
+		return true
+	}
+	return false
+}
+
+// Unwrap returns the equivalent MalformedMessage, which in turn unwraps to ErrInvalidMessage.
+func (m *ParseError) Unwrap() error {
+	return &MalformedMessage{m.detail()}
+}
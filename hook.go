@@ -0,0 +1,57 @@
+package siwe
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// VerifyStage identifies a step of signature verification.
+type VerifyStage int
+
+const (
+	// StageParsed fires once ParseAndVerify has parsed the message.
+	StageParsed VerifyStage = iota
+	// StageHashComputed fires once the EIP-191 digest has been computed.
+	StageHashComputed
+	// StageSignerRecovered fires once the signer address has been recovered,
+	// before it is compared with the message address.
+	StageSignerRecovered
+	// StageDone fires when verification completes, successfully or not.
+	StageDone
+)
+
+func (s VerifyStage) String() string {
+	switch s {
+	case StageParsed:
+		return "parsed"
+	case StageHashComputed:
+		return "hash computed"
+	case StageSignerRecovered:
+		return "signer recovered"
+	case StageDone:
+		return "done"
+	}
+	return "unknown"
+}
+
+// VerifyEvent describes a verification step, fields not relevant to the
+// stage are left zero.
+type VerifyEvent struct {
+	Stage   VerifyStage
+	Message *Message
+	Hash    common.Hash
+	Signer  common.Address
+	Err     error
+}
+
+// VerifyHook receives events as verification progresses, e.g. for logging.
+// VerifyBatch verifies messages in parallel, so a hook passed to it receives
+// events from several goroutines and must be safe for concurrent use.
+type VerifyHook interface {
+	OnVerifyEvent(event VerifyEvent)
+}
+
+func (opts *VerifyOptions) emit(event VerifyEvent) {
+	if opts.Hook != nil {
+		opts.Hook.OnVerifyEvent(event)
+	}
+}
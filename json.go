@@ -0,0 +1,137 @@
+package siwe
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+// MessageDTO is a flat representation of a Message for APIs and storage,
+// absent optional fields are left empty.
+type MessageDTO struct {
+	Scheme  string `json:"scheme,omitempty"`
+	Domain  string `json:"domain"`
+	Address string `json:"address"`
+	URI     string `json:"uri"`
+	Version string `json:"version,omitempty"`
+
+	Statement string `json:"statement,omitempty"`
+	Nonce     string `json:"nonce"`
+	ChainID   int    `json:"chainId,omitempty"`
+
+	IssuedAt       string `json:"issuedAt,omitempty"`
+	ExpirationTime string `json:"expirationTime,omitempty"`
+	NotBefore      string `json:"notBefore,omitempty"`
+
+	RequestID string   `json:"requestId,omitempty"`
+	Resources []string `json:"resources,omitempty"`
+}
+
+// ToDTO returns the flat representation of the message.
+func (m *Message) ToDTO() MessageDTO {
+	var resources []string
+	if len(m.resources) > 0 {
+		resources = make([]string, len(m.resources))
+		for i, resource := range m.resources {
+			resources[i] = resource.String()
+		}
+	}
+
+	return MessageDTO{
+		Scheme:  valueOrEmpty(m.scheme),
+		Domain:  m.domain,
+		Address: m.address.String(),
+		URI:     m.uri.String(),
+		Version: m.version,
+
+		Statement: valueOrEmpty(m.statement),
+		Nonce:     m.nonce,
+		ChainID:   m.chainID,
+
+		IssuedAt:       m.issuedAt,
+		ExpirationTime: valueOrEmpty(m.expirationTime),
+		NotBefore:      valueOrEmpty(m.notBefore),
+
+		RequestID: valueOrEmpty(m.requestID),
+		Resources: resources,
+	}
+}
+
+// FromDTO creates a Message from its flat representation, applying the same
+// defaults as InitMessage and validating the result like ParseMessage would.
+func FromDTO(dto MessageDTO) (*Message, error) {
+	options := make(map[string]interface{})
+
+	for key, value := range map[string]string{
+		"scheme":         dto.Scheme,
+		"version":        dto.Version,
+		"statement":      dto.Statement,
+		"issuedAt":       dto.IssuedAt,
+		"expirationTime": dto.ExpirationTime,
+		"notBefore":      dto.NotBefore,
+		"requestId":      dto.RequestID,
+	} {
+		if value != "" {
+			options[key] = value
+		}
+	}
+
+	if dto.ChainID != 0 {
+		options["chainId"] = dto.ChainID
+	}
+
+	if len(dto.Resources) > 0 {
+		resources := make([]url.URL, len(dto.Resources))
+		for i, resource := range dto.Resources {
+			parsed, err := url.Parse(resource)
+			if err != nil {
+				return nil, &MalformedMessage{fmt.Sprintf("Invalid format for field `resources` at position %d", i)}
+			}
+			resources[i] = *parsed
+		}
+		options["resources"] = resources
+	}
+
+	message, err := InitMessage(dto.Domain, dto.Address, dto.URI, dto.Nonce, options)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := message.Validate(); err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// MarshalJSON encodes the message as a JSON object keyed by the EIP-4361 field names.
+func (m Message) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.ToDTO())
+}
+
+// ParseMessageFromJSON returns a Message object from a JSON object keyed by the
+// EIP-4361 field names, applying the same defaults and validation as FromDTO.
+func ParseMessageFromJSON(data []byte) (*Message, error) {
+	var message Message
+	if err := json.Unmarshal(data, &message); err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// UnmarshalJSON decodes a JSON object produced by MarshalJSON, applying the
+// same defaults and validation as FromDTO.
+func (m *Message) UnmarshalJSON(data []byte) error {
+	var dto MessageDTO
+	if err := json.Unmarshal(data, &dto); err != nil {
+		return err
+	}
+
+	message, err := FromDTO(dto)
+	if err != nil {
+		return err
+	}
+
+	*m = *message
+	return nil
+}
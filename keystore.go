@@ -0,0 +1,20 @@
+package siwe
+
+import (
+	"encoding/hex"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+)
+
+// SignWithKeystore is like Sign, using an account held in a go-ethereum keystore
+// which is unlocked with passphrase for this signature only.
+func SignWithKeystore(message *Message, ks *keystore.KeyStore, account accounts.Account, passphrase string) (string, error) {
+	signature, err := ks.SignHashWithPassphrase(account, passphrase, message.eip191Hash().Bytes())
+	if err != nil {
+		return "", err
+	}
+
+	signature[64] += 27
+	return "0x" + hex.EncodeToString(signature), nil
+}
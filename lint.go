@@ -0,0 +1,136 @@
+package siwe
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// LintIssue describes a problem found in a message by Lint.
+type LintIssue struct {
+	// Line is the 1-based line the issue was found on, or 0 if it concerns
+	// the message as a whole.
+	Line    int
+	Section string
+	Message string
+}
+
+func (i LintIssue) String() string {
+	if i.Line == 0 {
+		return fmt.Sprintf("%s: %s", i.Section, i.Message)
+	}
+	return fmt.Sprintf("line %d: %s: %s", i.Line, i.Section, i.Message)
+}
+
+const _GREETING_SUFFIX = " wants you to sign in with your Ethereum account:"
+
+type lintField struct {
+	section string
+	label   string
+	pattern *regexp.Regexp
+	problem string
+}
+
+// Required fields in the order they must appear after the statement.
+var _LINT_FIELDS = []lintField{
+	{"uri", "URI: ", _STRICT_URI, "must be an RFC 3986 URI"},
+	{"version", "Version: ", regexp.MustCompile("^Version: 1$"), "must be 1"},
+	{"chainId", "Chain ID: ", _STRICT_CHAIN_ID, "must be a decimal integer"},
+	{"nonce", "Nonce: ", _STRICT_NONCE, fmt.Sprintf("must be at least %d alphanumeric characters", _NONCE_MIN_LENGTH)},
+	{"issuedAt", "Issued At: ", _STRICT_ISSUED_AT, "must be an RFC 3339 timestamp"},
+}
+
+var _LINT_OPTIONAL_FIELDS = []lintField{
+	{"expirationTime", "Expiration Time: ", _STRICT_EXPIRATION, "must be an RFC 3339 timestamp"},
+	{"notBefore", "Not Before: ", _STRICT_NOT_BEFORE, "must be an RFC 3339 timestamp"},
+	{"requestId", "Request ID: ", _STRICT_REQUEST_ID, "contains invalid characters"},
+}
+
+// lintFieldsStart returns the index of the first line after the statement
+// block, so a statement that looks like a field isn't mistaken for one.
+func lintFieldsStart(lines []string) int {
+	switch {
+	case len(lines) > 4 && lines[3] != "" && lines[4] == "":
+		// A statement followed by its blank line
+		return 5
+	case len(lines) > 3 && lines[3] == "":
+		// The blank line standing in for an absent statement
+		return 4
+	}
+	return 3
+}
+
+// Lint reports human-readable problems with an EIP-4361 formatted string
+// without parsing it, to help diagnose why a message is rejected. It returns
+// no issues for a well-formed message.
+func Lint(message string) []LintIssue {
+	var issues []LintIssue
+	report := func(line int, section, problem string) {
+		issues = append(issues, LintIssue{line, section, problem})
+	}
+
+	if strings.Contains(message, "\r") {
+		report(0, "message", "must use LF line endings")
+		message = strings.ReplaceAll(message, "\r\n", "\n")
+	}
+
+	lines := strings.Split(message, "\n")
+
+	if !strings.HasSuffix(lines[0], _GREETING_SUFFIX) {
+		report(1, "domain", fmt.Sprintf("greeting must end with %q", _GREETING_SUFFIX))
+	} else if !_STRICT_GREETING.MatchString(lines[0]) {
+		report(1, "domain", "is not a valid authority")
+	} else if !isASCII(lines[0]) {
+		report(1, "domain", "contains non-ASCII characters, use punycode for internationalized domains")
+	}
+
+	if len(lines) < 2 {
+		report(0, "address", "is missing")
+		return issues
+	}
+
+	address := lines[1]
+	if !_STRICT_ADDRESS.MatchString(address) {
+		report(2, "address", "must be 0x followed by 40 hexadecimal characters")
+	} else if common.HexToAddress(address).Hex() != address {
+		report(2, "address", "is not in EIP-55 checksum format")
+	}
+
+	if len(lines) < 3 || lines[2] != "" {
+		report(3, "address", "must be followed by a blank line")
+	}
+
+	fields := append(append([]lintField{}, _LINT_FIELDS...), _LINT_OPTIONAL_FIELDS...)
+	seen := make(map[string]bool)
+	for i := lintFieldsStart(lines); i < len(lines); i++ {
+		line := lines[i]
+		for _, field := range fields {
+			if !strings.HasPrefix(line, field.label) {
+				continue
+			}
+			if seen[field.section] {
+				report(i+1, field.section, "appears more than once")
+			}
+			seen[field.section] = true
+			if !field.pattern.MatchString(line) {
+				report(i+1, field.section, field.problem)
+			}
+		}
+	}
+
+	for _, field := range _LINT_FIELDS {
+		if !seen[field.section] {
+			report(0, field.section, fmt.Sprintf("is missing, expected a line starting with %q", field.label))
+		}
+	}
+
+	if len(issues) == 0 {
+		if _, err := ParseMessageStrict(message); err != nil {
+			report(0, "message", err.Error())
+		}
+	}
+
+	return issues
+}
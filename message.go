@@ -1,7 +1,9 @@
 package siwe
 
 import (
+	"fmt"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -9,6 +11,7 @@ import (
 )
 
 type Message struct {
+	scheme  *string
 	domain  string
 	address common.Address
 	uri     url.URL
@@ -24,6 +27,22 @@ type Message struct {
 
 	requestID *string
 	resources []url.URL
+
+	// rawAddress holds the address as provided, before checksum normalization
+	rawAddress string
+}
+
+func (m *Message) GetScheme() *string {
+	if m.scheme != nil {
+		ret := *m.scheme
+		return &ret
+	}
+	return nil
+}
+
+// GetSchemeValue returns the scheme, or an empty string if it's absent.
+func (m *Message) GetSchemeValue() string {
+	return valueOrEmpty(m.scheme)
 }
 
 func (m *Message) GetDomain() string {
@@ -50,26 +69,64 @@ func (m *Message) GetStatement() *string {
 	return nil
 }
 
+// GetStatementValue returns the statement, or an empty string if it's absent.
+func (m *Message) GetStatementValue() string {
+	return valueOrEmpty(m.statement)
+}
+
 func (m *Message) GetNonce() string {
 	return m.nonce
 }
 
+// GetChainID returns the chain ID, which is parsed once when the message is
+// built and serialized from the same integer, so the two can't diverge.
 func (m *Message) GetChainID() int {
 	return m.chainID
 }
 
+// GetCAIP2ChainID returns the chain ID in CAIP-2 form, e.g. "eip155:1".
+func (m *Message) GetCAIP2ChainID() (string, error) {
+	if m.chainID <= 0 {
+		return "", &MalformedMessage{"`chainId` must be a positive integer"}
+	}
+	return fmt.Sprintf("eip155:%d", m.chainID), nil
+}
+
 func (m *Message) GetIssuedAt() string {
 	return m.issuedAt
 }
 
+// ParseIssuedAt returns the issuance time of the message, the bool
+// reports whether the field is present.
+func (m *Message) ParseIssuedAt() (time.Time, bool, error) {
+	return parseOptionalTimestamp(&m.issuedAt)
+}
+
+func parseOptionalTimestamp(value *string) (time.Time, bool, error) {
+	if isBlank(value) {
+		return time.Time{}, false, nil
+	}
+
+	ret, err := iso8601.ParseString(*value)
+	if err != nil {
+		return time.Time{}, true, err
+	}
+	return ret, true, nil
+}
+
 func (m *Message) getExpirationTime() *time.Time {
-	if !isEmpty(m.expirationTime) {
-		ret, _ := iso8601.ParseString(*m.expirationTime)
+	if ret, ok, _ := m.ParseExpirationTime(); ok {
 		return &ret
 	}
 	return nil
 }
 
+// ParseExpirationTime returns the expiration time of the message, the bool
+// reports whether the field is present.
+func (m *Message) ParseExpirationTime() (time.Time, bool, error) {
+	return parseOptionalTimestamp(m.expirationTime)
+}
+
 func (m *Message) GetExpirationTime() *string {
 	if m.expirationTime != nil {
 		ret := *m.expirationTime
@@ -78,14 +135,24 @@ func (m *Message) GetExpirationTime() *string {
 	return nil
 }
 
+// GetExpirationTimeValue returns the expiration time, or an empty string if it's absent.
+func (m *Message) GetExpirationTimeValue() string {
+	return valueOrEmpty(m.expirationTime)
+}
+
 func (m *Message) getNotBefore() *time.Time {
-	if !isEmpty(m.notBefore) {
-		ret, _ := iso8601.ParseString(*m.notBefore)
+	if ret, ok, _ := m.ParseNotBefore(); ok {
 		return &ret
 	}
 	return nil
 }
 
+// ParseNotBefore returns the not-before time of the message, the bool
+// reports whether the field is present.
+func (m *Message) ParseNotBefore() (time.Time, bool, error) {
+	return parseOptionalTimestamp(m.notBefore)
+}
+
 func (m *Message) GetNotBefore() *string {
 	if m.notBefore != nil {
 		ret := *m.notBefore
@@ -94,6 +161,11 @@ func (m *Message) GetNotBefore() *string {
 	return nil
 }
 
+// GetNotBeforeValue returns the not before time, or an empty string if it's absent.
+func (m *Message) GetNotBeforeValue() string {
+	return valueOrEmpty(m.notBefore)
+}
+
 func (m *Message) GetRequestID() *string {
 	if m.requestID != nil {
 		ret := *m.requestID
@@ -102,6 +174,180 @@ func (m *Message) GetRequestID() *string {
 	return nil
 }
 
+// GetRequestIDValue returns the request ID, or an empty string if it's absent.
+func (m *Message) GetRequestIDValue() string {
+	return valueOrEmpty(m.requestID)
+}
+
 func (m *Message) GetResources() []url.URL {
 	return m.resources
 }
+
+// GetResourceURLs returns copies of the message resources, failing on the
+// first one that isn't an absolute URI.
+func (m *Message) GetResourceURLs() ([]*url.URL, error) {
+	if err := validateResources(m.resources); err != nil {
+		return nil, err
+	}
+
+	resources := make([]*url.URL, len(m.resources))
+	for i := range m.resources {
+		resource := m.resources[i]
+		resources[i] = &resource
+	}
+	return resources, nil
+}
+
+func equalOptional(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// Equal reports whether both messages hold the same fields, comparing
+// optional values by content and resources in order.
+func (m *Message) Equal(other *Message) bool {
+	if m == nil || other == nil {
+		return m == other
+	}
+
+	if !equalOptional(m.scheme, other.scheme) ||
+		m.domain != other.domain ||
+		m.address != other.address ||
+		m.uri.String() != other.uri.String() ||
+		m.version != other.version ||
+		!equalOptional(m.statement, other.statement) ||
+		m.nonce != other.nonce ||
+		m.chainID != other.chainID ||
+		m.issuedAt != other.issuedAt ||
+		!equalOptional(m.expirationTime, other.expirationTime) ||
+		!equalOptional(m.notBefore, other.notBefore) ||
+		!equalOptional(m.requestID, other.requestID) ||
+		len(m.resources) != len(other.resources) {
+		return false
+	}
+
+	for i := range m.resources {
+		if m.resources[i].String() != other.resources[i].String() {
+			return false
+		}
+	}
+
+	return true
+}
+
+func cloneOptional(str *string) *string {
+	if str == nil {
+		return nil
+	}
+	ret := *str
+	return &ret
+}
+
+// Clone returns a deep copy of the message, sharing no optional values or resources with it.
+func (m *Message) Clone() *Message {
+	clone := *m
+
+	clone.scheme = cloneOptional(m.scheme)
+	clone.statement = cloneOptional(m.statement)
+	clone.expirationTime = cloneOptional(m.expirationTime)
+	clone.notBefore = cloneOptional(m.notBefore)
+	clone.requestID = cloneOptional(m.requestID)
+
+	if m.resources != nil {
+		clone.resources = make([]url.URL, len(m.resources))
+		copy(clone.resources, m.resources)
+	}
+
+	return &clone
+}
+
+// SetNonce replaces the nonce of the message, e.g. to stamp a fresh nonce on
+// a template message. The message must not be in use by other goroutines.
+func (m *Message) SetNonce(nonce string) error {
+	if !ValidNonce(nonce) {
+		return &MalformedMessage{"`nonce` must be at least 8 alphanumeric characters"}
+	}
+	m.nonce = nonce
+	return nil
+}
+
+// WithFreshTimestamps returns a copy of the message issued now and, if validFor
+// is positive, expiring validFor later. Otherwise the copy has no expiration time.
+func (m *Message) WithFreshTimestamps(validFor time.Duration) *Message {
+	clone := m.Clone()
+
+	now := time.Now().UTC()
+	clone.issuedAt = now.Format(time.RFC3339)
+	clone.expirationTime = nil
+	if validFor > 0 {
+		expirationTime := now.Add(validFor).Format(time.RFC3339)
+		clone.expirationTime = &expirationTime
+	}
+
+	return clone
+}
+
+// PresentFields returns the names of the optional fields set on the message,
+// in message order, using the same emptiness rule as String.
+func (m *Message) PresentFields() []string {
+	var fields []string
+	for _, field := range []struct {
+		name  string
+		value *string
+	}{
+		{"scheme", m.scheme},
+		{"statement", m.statement},
+		{"expirationTime", m.expirationTime},
+		{"notBefore", m.notBefore},
+		{"requestId", m.requestID},
+	} {
+		if !isBlank(field.value) {
+			fields = append(fields, field.name)
+		}
+	}
+
+	if len(m.resources) > 0 {
+		fields = append(fields, "resources")
+	}
+
+	return fields
+}
+
+// Canonicalize returns a copy of the message with its fields in canonical
+// form: an EIP-55 address, UTC RFC 3339 timestamps and a trimmed statement.
+// Semantically equal messages canonicalize to the same signed text.
+func (m *Message) Canonicalize() (*Message, error) {
+	clone := m.Clone()
+	clone.rawAddress = clone.address.Hex()
+
+	if clone.statement != nil {
+		statement := strings.TrimSpace(*clone.statement)
+		clone.statement = &statement
+		if statement == "" {
+			clone.statement = nil
+		}
+	}
+
+	timestamps := []struct {
+		key   string
+		value *string
+	}{
+		{"issuedAt", &clone.issuedAt},
+		{"expirationTime", clone.expirationTime},
+		{"notBefore", clone.notBefore},
+	}
+	for _, timestamp := range timestamps {
+		if timestamp.value == nil {
+			continue
+		}
+		parsed, err := iso8601.ParseString(*timestamp.value)
+		if err != nil {
+			return nil, &MalformedMessage{fmt.Sprintf("Invalid format for field `%s`", timestamp.key)}
+		}
+		*timestamp.value = parsed.UTC().Format(time.RFC3339Nano)
+	}
+
+	return clone, nil
+}
@@ -0,0 +1,101 @@
+package siwe
+
+import (
+	"sync"
+	"time"
+)
+
+// NonceStore issues nonces and consumes them at most once, so a signed
+// message can't be replayed.
+type NonceStore interface {
+	// Issue generates and remembers a new nonce.
+	Issue() (string, error)
+	// Consume reports whether nonce was issued and not yet consumed or expired,
+	// forgetting it either way.
+	Consume(nonce string) (bool, error)
+}
+
+// MemoryNonceStore is a goroutine-safe in-memory NonceStore whose nonces expire after a TTL.
+type MemoryNonceStore struct {
+	ttl       time.Duration
+	now       func() time.Time
+	mu        sync.Mutex
+	nonces    map[string]time.Time
+	nextSweep time.Time
+}
+
+// NewMemoryNonceStore creates a MemoryNonceStore whose nonces expire after ttl.
+func NewMemoryNonceStore(ttl time.Duration) *MemoryNonceStore {
+	return &MemoryNonceStore{
+		ttl:    ttl,
+		now:    time.Now,
+		nonces: make(map[string]time.Time),
+	}
+}
+
+// Issue generates a new nonce and remembers it until it's consumed or expires.
+func (s *MemoryNonceStore) Issue() (string, error) {
+	nonce, err := GenerateNonceN(16)
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.evict(now)
+	s.nonces[nonce] = now.Add(s.ttl)
+
+	return nonce, nil
+}
+
+// Consume reports whether nonce was issued by the store and hasn't expired or
+// been consumed before.
+func (s *MemoryNonceStore) Consume(nonce string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expiresAt, ok := s.nonces[nonce]
+	if !ok {
+		return false, nil
+	}
+
+	delete(s.nonces, nonce)
+	return s.now().Before(expiresAt), nil
+}
+
+// evict drops expired nonces, scanning the store at most once per TTL so
+// issuing stays cheap. Consume checks expiry itself.
+func (s *MemoryNonceStore) evict(now time.Time) {
+	if now.Before(s.nextSweep) {
+		return
+	}
+	s.nextSweep = now.Add(s.ttl)
+
+	for nonce, expiresAt := range s.nonces {
+		if !now.Before(expiresAt) {
+			delete(s.nonces, nonce)
+		}
+	}
+}
+
+// VerifyAndConsume parses and verifies message like ParseAndVerify, then
+// consumes its nonce from store, so a message can only be verified once.
+func VerifyAndConsume(message, signature string, store NonceStore, opts VerifyOptions) (*Message, error) {
+	parsed, err := ParseAndVerify(message, signature, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := store.Consume(parsed.GetNonce())
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, &NonceMismatch{"Message nonce is unknown or was already used"}
+	}
+
+	return parsed, nil
+}
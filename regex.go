@@ -3,20 +3,34 @@ package siwe
 import (
 	"fmt"
 	"regexp"
+	"strings"
 )
 
-const _SIWE_DOMAIN = "(?P<domain>([^/?#]+)) wants you to sign in with your Ethereum account:\\n"
-const _SIWE_ADDRESS = "(?P<address>0x[a-zA-Z0-9]{40})\\n\\n"
-const _SIWE_STATEMENT = "((?P<statement>[^\\n]+)\\n)?\\n"
+const _SIWE_SCHEME = "[a-zA-Z][a-zA-Z0-9+\\-.]*"
+
+var _SIWE_DOMAIN = fmt.Sprintf("((?P<scheme>%s)://)?(?P<domain>([^/?#]+)) wants you to sign in with your Ethereum account:\\n", _SIWE_SCHEME)
+var _SIWE_SCHEME_VALUE = regexp.MustCompile(fmt.Sprintf("^%s$", _SIWE_SCHEME))
+
+const _SIWE_ADDRESS = "(?P<address>0x[a-fA-F0-9]{40})\\n\\n"
+
+// Without a statement the canonical form has two blank lines after the address,
+// some wallets collapse them into one so both are accepted.
+const _SIWE_STATEMENT = "((?P<statement>[^\\n]+)\\n\\n|\\n)?"
 const _RFC3986 = "(([^ :/?#]+):)?(//([^ /?#]*))?([^ ?#]*)(\\?([^ #]*))?(#(.*))?"
 
 var _SIWE_URI_LINE = fmt.Sprintf("URI: (?P<uri>%s?)\\n", _RFC3986)
 
-const _SIWE_VERSION = "Version: (?P<version>1)\\n"
+const _SIWE_VERSION = "Version: (?P<version>[0-9]+)\\n"
 const _SIWE_CHAIN_ID = "Chain ID: (?P<chainId>[0-9]+)\\n"
-const _SIWE_NONCE = "Nonce: (?P<nonce>[a-zA-Z0-9]{8,})\\n"
+const _NONCE = "[a-zA-Z0-9]{8,}"
+
+var _SIWE_NONCE = fmt.Sprintf("Nonce: (?P<nonce>%s)\\n", _NONCE)
+var _SIWE_NONCE_VALUE = regexp.MustCompile(fmt.Sprintf("^%s$", _NONCE))
+
 const _SIWE_DATETIME = "([0-9]+)-(0[1-9]|1[012])-(0[1-9]|[12][0-9]|3[01])[Tt]([01][0-9]|2[0-3]):([0-5][0-9]):([0-5][0-9]|60)(\\.[0-9]+)?(([Zz])|([\\+|\\-]([01][0-9]|2[0-3]):[0-5][0-9]))"
 
+var _SIWE_DATETIME_VALUE = regexp.MustCompile(fmt.Sprintf("^%s$", _SIWE_DATETIME))
+
 var _SIWE_ISSUED_AT = fmt.Sprintf("Issued At: (?P<issuedAt>%s)", _SIWE_DATETIME)
 var _SIWE_EXPIRATION_TIME = fmt.Sprintf("(\\nExpiration Time: (?P<expirationTime>%s))?", _SIWE_DATETIME)
 var _SIWE_NOT_BEFORE = fmt.Sprintf("(\\nNot Before: (?P<notBefore>%s))?", _SIWE_DATETIME)
@@ -25,16 +39,109 @@ const _SIWE_REQUEST_ID = "(\\nRequest ID: (?P<requestId>[-._~!$&'()*+,;=:@%a-zA-
 
 var _SIWE_RESOURCES = fmt.Sprintf("(\\nResources:(?P<resources>(\\n- %s)+))?", _RFC3986)
 
-var _SIWE_MESSAGE = regexp.MustCompile(fmt.Sprintf("^%s%s%s%s%s%s%s%s%s%s%s%s$",
-	_SIWE_DOMAIN,
-	_SIWE_ADDRESS,
-	_SIWE_STATEMENT,
-	_SIWE_URI_LINE,
-	_SIWE_VERSION,
-	_SIWE_CHAIN_ID,
-	_SIWE_NONCE,
-	_SIWE_ISSUED_AT,
-	_SIWE_EXPIRATION_TIME,
-	_SIWE_NOT_BEFORE,
-	_SIWE_REQUEST_ID,
-	_SIWE_RESOURCES))
+type grammarSection struct {
+	name    string
+	pattern string
+	label   string
+}
+
+// _SIWE_SECTIONS lists the EIP-4361 grammar in order, it is used to
+// localize which section of a message failed to match.
+var _SIWE_SECTIONS = []grammarSection{
+	{"domain", _SIWE_DOMAIN, ""},
+	{"address", _SIWE_ADDRESS, ""},
+	{"statement", _SIWE_STATEMENT, ""},
+	{"uri", _SIWE_URI_LINE, ""},
+	{"version", _SIWE_VERSION, ""},
+	{"chainId", _SIWE_CHAIN_ID, ""},
+	{"nonce", _SIWE_NONCE, ""},
+	{"issuedAt", _SIWE_ISSUED_AT, ""},
+	{"expirationTime", _SIWE_EXPIRATION_TIME, "\nExpiration Time:"},
+	{"notBefore", _SIWE_NOT_BEFORE, "\nNot Before:"},
+	{"requestId", _SIWE_REQUEST_ID, "\nRequest ID:"},
+	{"resources", _SIWE_RESOURCES, "\nResources:"},
+}
+
+func buildGrammar(sections []grammarSection) string {
+	patterns := make([]string, len(sections))
+	for i, section := range sections {
+		patterns[i] = section.pattern
+	}
+	return strings.Join(patterns, "")
+}
+
+var _SIWE_MESSAGE = regexp.MustCompile(fmt.Sprintf("^%s$", buildGrammar(_SIWE_SECTIONS)))
+
+// _SIWE_MINIMAL_MESSAGE is the grammar without the labelled optional sections,
+// a faster match for messages which contain none of their labels.
+var _SIWE_MINIMAL_MESSAGE = func() *regexp.Regexp {
+	var required []grammarSection
+	for _, section := range _SIWE_SECTIONS {
+		if section.label == "" {
+			required = append(required, section)
+		}
+	}
+	return regexp.MustCompile(fmt.Sprintf("^%s$", buildGrammar(required)))
+}()
+
+// grammarFor returns the cheapest grammar able to match message.
+func grammarFor(message string) *regexp.Regexp {
+	for _, section := range _SIWE_SECTIONS {
+		if section.label != "" && strings.Contains(message, section.label) {
+			return _SIWE_MESSAGE
+		}
+	}
+	return _SIWE_MINIMAL_MESSAGE
+}
+
+// _SIWE_PREFIXES holds, for each section, the grammar up to and including it.
+var _SIWE_PREFIXES = func() []*regexp.Regexp {
+	prefixes := make([]*regexp.Regexp, len(_SIWE_SECTIONS))
+	for i := range _SIWE_SECTIONS {
+		prefixes[i] = regexp.MustCompile(fmt.Sprintf("^%s", buildGrammar(_SIWE_SECTIONS[:i+1])))
+	}
+	return prefixes
+}()
+
+// locateParseFailure returns the name of the first grammar section that
+// message fails to match, or an empty string if it can't be determined.
+func locateParseFailure(message string) string {
+	end := 0
+	for i, prefix := range _SIWE_PREFIXES {
+		loc := prefix.FindStringIndex(message)
+		if loc == nil {
+			return _SIWE_SECTIONS[i].name
+		}
+		end = loc[1]
+	}
+
+	// Every section matched, so the failure lies in malformed optional lines
+	rest := message[end:]
+	for _, section := range _SIWE_SECTIONS {
+		if section.label != "" && strings.HasPrefix(rest, section.label) {
+			return section.name
+		}
+	}
+
+	return ""
+}
+
+// MatchFields matches message against the EIP-4361 grammar, returning the
+// non-empty named groups it captured, e.g. "domain", "nonce" or "resources",
+// and whether the message matched at all. Captured values aren't validated.
+func MatchFields(message string) (map[string]string, bool) {
+	grammar := grammarFor(message)
+	match := grammar.FindStringSubmatch(message)
+	if match == nil {
+		return nil, false
+	}
+
+	fields := make(map[string]string)
+	for i, name := range grammar.SubexpNames() {
+		if i != 0 && name != "" && match[i] != "" {
+			fields[name] = match[i]
+		}
+	}
+
+	return fields, true
+}
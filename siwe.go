@@ -1,18 +1,44 @@
 package siwe
 
 import (
+	"bytes"
+	"context"
 	"crypto/ecdsa"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"math/big"
+	"net"
 	"net/url"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/relvacode/iso8601"
 )
 
+// Version is the EIP-4361 message version produced by this package.
+const Version = "1"
+
+// DefaultChainID is used by InitMessage when no `chainId` option is given,
+// services running on a single chain can set it once at startup.
+var DefaultChainID = 1
+
+// SupportedVersions lists the EIP-4361 message versions this package accepts.
+var SupportedVersions = []string{Version}
+
+func validateVersion(version string) error {
+	for _, supported := range SupportedVersions {
+		if version == supported {
+			return nil
+		}
+	}
+	return &InvalidVersion{fmt.Sprintf("Unsupported message version `%s`", version)}
+}
+
 func buildAuthority(uri *url.URL) string {
 	authority := uri.Host
 	if uri.User != nil {
@@ -22,44 +48,74 @@ func buildAuthority(uri *url.URL) string {
 }
 
 func validateDomain(domain *string) (bool, error) {
-	if isEmpty(domain) {
-		return false, &InvalidMessage{"`domain` must not be empty"}
+	if isBlank(domain) {
+		return false, &MalformedMessage{"`domain` must not be empty"}
 	}
 
 	validateDomain, err := url.Parse(fmt.Sprintf("https://%s", *domain))
 	if err != nil {
-		return false, &InvalidMessage{"Invalid format for field `domain`"}
+		return false, &MalformedMessage{"Invalid format for field `domain`"}
 	}
 
 	authority := buildAuthority(validateDomain)
 	if authority != *domain {
-		return false, &InvalidMessage{"Invalid format for field `domain`"}
+		return false, &MalformedMessage{"Invalid format for field `domain`"}
+	}
+
+	return true, nil
+}
+
+func validateAddress(address *string) (bool, error) {
+	if isBlank(address) {
+		return false, &MalformedMessage{"`address` must not be empty"}
+	}
+
+	if !common.IsHexAddress(*address) {
+		return false, &MalformedMessage{"Invalid format for field `address`"}
+	}
+
+	// Single-case addresses carry no checksum, mixed-case ones must be valid EIP-55
+	digits := strings.TrimPrefix(*address, "0x")
+	if digits != strings.ToLower(digits) && digits != strings.ToUpper(digits) {
+		if common.HexToAddress(*address).Hex() != *address {
+			return false, &MalformedMessage{"Address has an invalid EIP-55 checksum"}
+		}
 	}
 
 	return true, nil
 }
 
 func validateURI(uri *string) (*url.URL, error) {
-	if isEmpty(uri) {
-		return nil, &InvalidMessage{"`uri` must not be empty"}
+	if isBlank(uri) {
+		return nil, &MalformedMessage{"`uri` must not be empty"}
 	}
 
 	validateURI, err := url.Parse(*uri)
-	if err != nil {
-		return nil, &InvalidMessage{"Invalid format for field `uri`"}
+	if err != nil || !validateURI.IsAbs() {
+		return nil, &MalformedMessage{"Invalid format for field `uri`"}
 	}
 
 	return validateURI, nil
 }
 
+func validateResources(resources []url.URL) error {
+	for i, resource := range resources {
+		value := resource.String()
+		if _, err := validateURI(&value); err != nil {
+			return &MalformedMessage{fmt.Sprintf("Invalid format for field `resources` at position %d", i)}
+		}
+	}
+	return nil
+}
+
 // InitMessage creates a Message object with the provided parameters
 func InitMessage(domain, address, uri, nonce string, options map[string]interface{}) (*Message, error) {
 	if ok, err := validateDomain(&domain); !ok {
 		return nil, err
 	}
 
-	if isEmpty(&address) {
-		return nil, &InvalidMessage{"`address` must not be empty"}
+	if ok, err := validateAddress(&address); !ok {
+		return nil, err
 	}
 
 	validateURI, err := validateURI(&uri)
@@ -67,13 +123,37 @@ func InitMessage(domain, address, uri, nonce string, options map[string]interfac
 		return nil, err
 	}
 
-	if isEmpty(&nonce) {
-		return nil, &InvalidMessage{"`nonce` must not be empty"}
+	if isBlank(&nonce) {
+		return nil, &MalformedMessage{"`nonce` must not be empty"}
+	}
+
+	version := Version
+	if val, ok := isStringAndNotEmpty(options, "version"); ok {
+		if err := validateVersion(*val); err != nil {
+			return nil, err
+		}
+		version = *val
+	}
+
+	var scheme *string
+	if val, ok := isStringAndNotEmpty(options, "scheme"); ok {
+		if !_SIWE_SCHEME_VALUE.MatchString(*val) {
+			return nil, &MalformedMessage{"Invalid format for field `scheme`"}
+		}
+		scheme = val
 	}
 
 	var statement *string
 	if val, ok := options["statement"]; ok {
-		value := val.(string)
+		value, ok := val.(string)
+		if !ok {
+			return nil, &MalformedMessage{"`statement` must be a string"}
+		}
+		// EIP-4361 statements are a single line, wrapped text would be
+		// indistinguishable from the fields that follow it
+		if strings.ContainsAny(value, "\r\n") {
+			return nil, &MalformedMessage{"`statement` must not contain line breaks"}
+		}
 		statement = &value
 	}
 
@@ -81,20 +161,27 @@ func InitMessage(domain, address, uri, nonce string, options map[string]interfac
 	if val, ok := options["chainId"]; ok {
 		switch val.(type) {
 		case float64:
+			if val.(float64) != float64(int(val.(float64))) {
+				return nil, &MalformedMessage{"Invalid format for field `chainId`, must be an integer"}
+			}
 			chainId = int(val.(float64))
 		case int:
 			chainId = val.(int)
 		case string:
 			parsed, err := strconv.Atoi(val.(string))
 			if err != nil {
-				return nil, &InvalidMessage{"Invalid format for field `chainId`, must be an integer"}
+				return nil, &MalformedMessage{"Invalid format for field `chainId`, must be an integer"}
 			}
 			chainId = parsed
 		default:
-			return nil, &InvalidMessage{"`chainId` must be a string or a integer"}
+			return nil, &MalformedMessage{"`chainId` must be a string or a integer"}
 		}
 	} else {
-		chainId = 1
+		chainId = DefaultChainID
+	}
+
+	if chainId < 1 {
+		return nil, &MalformedMessage{"`chainId` must be a positive integer"}
 	}
 
 	var issuedAt string
@@ -138,17 +225,24 @@ func InitMessage(domain, address, uri, nonce string, options map[string]interfac
 	if val, ok := options["resources"]; ok {
 		switch val.(type) {
 		case []url.URL:
-			resources = val.([]url.URL)
+			// Copy so the message doesn't share state with the caller's slice
+			resources = append([]url.URL(nil), val.([]url.URL)...)
 		default:
-			return nil, &InvalidMessage{"`resources` must be a []url.URL"}
+			return nil, &MalformedMessage{"`resources` must be a []url.URL"}
 		}
 	}
 
+	if err := validateResources(resources); err != nil {
+		return nil, err
+	}
+
 	return &Message{
-		domain:  domain,
-		address: common.HexToAddress(address),
-		uri:     *validateURI,
-		version: "1",
+		scheme:     scheme,
+		domain:     domain,
+		address:    common.HexToAddress(address),
+		rawAddress: address,
+		uri:        *validateURI,
+		version:    version,
 
 		statement: statement,
 		nonce:     nonce,
@@ -163,40 +257,177 @@ func InitMessage(domain, address, uri, nonce string, options map[string]interfac
 	}, nil
 }
 
-func parseMessage(message string) (map[string]interface{}, error) {
-	match := _SIWE_MESSAGE.FindStringSubmatch(message)
+// Validate checks that the message is structurally well-formed, without
+// evaluating time constraints or a signature, and returns the first violation found.
+func (m *Message) Validate() error {
+	if ok, err := validateDomain(&m.domain); !ok {
+		return err
+	}
 
-	if match == nil {
-		return nil, &InvalidMessage{"Message could not be parsed"}
+	if m.address == (common.Address{}) {
+		return &MalformedMessage{"`address` must not be empty"}
 	}
 
-	result := make(map[string]interface{})
-	for i, name := range _SIWE_MESSAGE.SubexpNames() {
-		if i != 0 && name != "" && match[i] != "" {
-			result[name] = match[i]
+	uri := m.uri.String()
+	if _, err := validateURI(&uri); err != nil {
+		return err
+	}
+
+	if err := validateVersion(m.version); err != nil {
+		return err
+	}
+
+	if !ValidNonce(m.nonce) {
+		return &MalformedMessage{"`nonce` must be at least 8 alphanumeric characters"}
+	}
+
+	if m.chainID < 1 {
+		return &MalformedMessage{"`chainId` must be a positive integer"}
+	}
+
+	if err := validateResources(m.resources); err != nil {
+		return err
+	}
+
+	timestamps := []struct {
+		key   string
+		value *string
+	}{
+		{"issuedAt", &m.issuedAt},
+		{"expirationTime", m.expirationTime},
+		{"notBefore", m.notBefore},
+	}
+	for _, timestamp := range timestamps {
+		if timestamp.value == nil {
+			continue
+		}
+		if _, err := iso8601.ParseString(*timestamp.value); err != nil {
+			return &MalformedMessage{fmt.Sprintf("Invalid format for field `%s`", timestamp.key)}
+		}
+	}
+
+	return nil
+}
+
+// MessageOptions holds the optional fields of a message built by NewMessage,
+// zero values leave the field absent or use InitMessage's default.
+type MessageOptions struct {
+	Scheme    string
+	Statement string
+	ChainID   int
+	// Nonce defaults to one generated by GenerateNonce.
+	Nonce          string
+	IssuedAt       string
+	ExpirationTime string
+	NotBefore      string
+	RequestID      string
+	Resources      []url.URL
+}
+
+// NewMessage creates a message like InitMessage from typed options, and
+// validates it, so an invalid message is never returned.
+func NewMessage(domain, address, uri, version string, opts MessageOptions) (*Message, error) {
+	options := make(map[string]interface{})
+	for key, value := range map[string]string{
+		"version":        version,
+		"scheme":         opts.Scheme,
+		"statement":      opts.Statement,
+		"issuedAt":       opts.IssuedAt,
+		"expirationTime": opts.ExpirationTime,
+		"notBefore":      opts.NotBefore,
+		"requestId":      opts.RequestID,
+	} {
+		if value != "" {
+			options[key] = value
+		}
+	}
+
+	if opts.ChainID != 0 {
+		options["chainId"] = opts.ChainID
+	}
+
+	if len(opts.Resources) > 0 {
+		options["resources"] = opts.Resources
+	}
+
+	nonce := opts.Nonce
+	if nonce == "" {
+		generated, err := GenerateNonce()
+		if err != nil {
+			return nil, err
 		}
+		nonce = generated
+	}
+
+	message, err := InitMessage(domain, address, uri, nonce, options)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := message.Validate(); err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// _REQUIRED_FIELDS are the fields passed positionally to InitMessage, in order.
+var _REQUIRED_FIELDS = []string{"domain", "address", "uri", "nonce"}
+
+// requiredField returns the non-empty string captured for key, or an error
+// rather than panicking if the grammar produced none.
+func requiredField(result map[string]interface{}, key string) (string, error) {
+	value, ok := result[key].(string)
+	if !ok || value == "" {
+		return "", &MalformedMessage{fmt.Sprintf("`%s` must not be empty", key)}
 	}
+	return value, nil
+}
+
+func parseMessage(message string) (map[string]interface{}, error) {
+	// Only the structure is parsed from the normalized text, signatures are
+	// still checked against the canonical LF form rebuilt from the fields
+	message = strings.ReplaceAll(message, "\r\n", "\n")
 
-	if _, ok := result["domain"]; !ok {
-		return nil, &InvalidMessage{"`domain` must not be empty"}
+	fields, ok := MatchFields(message)
+	if !ok {
+		return nil, &ParseError{locateParseFailure(message)}
+	}
+
+	result := make(map[string]interface{}, len(fields))
+	for name, value := range fields {
+		result[name] = value
+	}
+
+	domain, err := requiredField(result, "domain")
+	if err != nil {
+		return nil, err
 	}
-	domain := result["domain"].(string)
 	if ok, err := validateDomain(&domain); !ok {
 		return nil, err
 	}
 
-	if _, ok := result["uri"]; !ok {
-		return nil, &InvalidMessage{"`domain` must not be empty"}
+	uri, err := requiredField(result, "uri")
+	if err != nil {
+		return nil, err
 	}
-	uri := result["uri"].(string)
-	if _, err := validateURI(&uri); err != nil {
+	parsedURI, err := validateURI(&uri)
+	if err != nil {
 		return nil, err
 	}
 
-	originalAddress := result["address"].(string)
+	// URIs that net/url would rewrite can't be serialized back to the signed text
+	if parsedURI.String() != uri {
+		return nil, &MalformedMessage{"Invalid format for field `uri`"}
+	}
+
+	originalAddress, err := requiredField(result, "address")
+	if err != nil {
+		return nil, err
+	}
 	parsedAddress := common.HexToAddress(originalAddress)
 	if originalAddress != parsedAddress.String() {
-		return nil, &InvalidMessage{"Address must be in EIP-55 format"}
+		return nil, &MalformedMessage{"Address must be in EIP-55 format"}
 	}
 
 	if val, ok := result["resources"]; ok {
@@ -204,8 +435,8 @@ func parseMessage(message string) (map[string]interface{}, error) {
 		validateResources := make([]url.URL, len(resources))
 		for i, resource := range resources {
 			validateResource, err := url.Parse(resource)
-			if err != nil {
-				return nil, &InvalidMessage{fmt.Sprintf("Invalid format for field `resources` at position %d", i)}
+			if err != nil || validateResource.String() != resource {
+				return nil, &MalformedMessage{fmt.Sprintf("Invalid format for field `resources` at position %d", i)}
 			}
 			validateResources[i] = *validateResource
 		}
@@ -215,21 +446,89 @@ func parseMessage(message string) (map[string]interface{}, error) {
 	return result, nil
 }
 
-// ParseMessage returns a Message object by parsing an EIP-4361 formatted string
+// ParseLimits bounds the size of untrusted messages accepted by the parser,
+// a zero value disables the corresponding limit.
+type ParseLimits struct {
+	MaxMessageBytes    int
+	MaxStatementLength int
+	MaxResources       int
+}
+
+// DefaultParseLimits are the limits applied by ParseMessage and
+// ParseMessageReader, services can set them once at startup.
+var DefaultParseLimits = ParseLimits{
+	MaxMessageBytes:    64 * 1024,
+	MaxStatementLength: 4096,
+	MaxResources:       256,
+}
+
+func (limits *ParseLimits) check(result map[string]interface{}) error {
+	if val, ok := result["statement"].(string); ok && limits.MaxStatementLength > 0 {
+		if len(val) > limits.MaxStatementLength {
+			return &InvalidMessage{fmt.Sprintf("`statement` exceeds the maximum length of %d bytes", limits.MaxStatementLength)}
+		}
+	}
+
+	if val, ok := result["resources"].([]url.URL); ok && limits.MaxResources > 0 {
+		if len(val) > limits.MaxResources {
+			return &InvalidMessage{fmt.Sprintf("`resources` exceeds the maximum of %d entries", limits.MaxResources)}
+		}
+	}
+
+	return nil
+}
+
+// ParseMessage returns a Message object by parsing an EIP-4361 formatted string.
+// CRLF line endings are accepted and treated as LF, note that signatures are
+// verified against the LF form, so a wallet that signed CRLF text won't verify.
 func ParseMessage(message string) (*Message, error) {
+	return ParseMessageWithLimits(message, DefaultParseLimits)
+}
+
+// ParseMessageReader is like ParseMessage, reading the message from r. At most
+// DefaultParseLimits.MaxMessageBytes are read, larger inputs are rejected,
+// unless the limit is zero.
+func ParseMessageReader(r io.Reader) (*Message, error) {
+	limit := DefaultParseLimits.MaxMessageBytes
+	if limit > 0 {
+		r = io.LimitReader(r, int64(limit)+1)
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if limit > 0 && len(data) > limit {
+		return nil, &InvalidMessage{fmt.Sprintf("Message exceeds the maximum length of %d bytes", limit)}
+	}
+
+	return ParseMessage(string(data))
+}
+
+// ParseMessageWithLimits is like ParseMessage, rejecting messages that exceed the given limits.
+func ParseMessageWithLimits(message string, limits ParseLimits) (*Message, error) {
+	if limits.MaxMessageBytes > 0 && len(message) > limits.MaxMessageBytes {
+		return nil, &InvalidMessage{fmt.Sprintf("Message exceeds the maximum length of %d bytes", limits.MaxMessageBytes)}
+	}
+
 	result, err := parseMessage(message)
 	if err != nil {
 		return nil, err
 	}
 
-	parsed, err := InitMessage(
-		result["domain"].(string),
-		result["address"].(string),
-		result["uri"].(string),
-		result["nonce"].(string),
-		result,
-	)
+	if err := limits.check(result); err != nil {
+		return nil, err
+	}
+
+	required := make([]string, len(_REQUIRED_FIELDS))
+	for i, key := range _REQUIRED_FIELDS {
+		if required[i], err = requiredField(result, key); err != nil {
+			return nil, err
+		}
+	}
 
+	parsed, err := InitMessage(required[0], required[1], required[2], required[3], result)
 	if err != nil {
 		return nil, err
 	}
@@ -237,11 +536,44 @@ func ParseMessage(message string) (*Message, error) {
 	return parsed, nil
 }
 
+const _EIP191_PREFIX = "\x19Ethereum Signed Message:\n"
+
 func (m *Message) eip191Hash() common.Hash {
 	// Ref: https://stackoverflow.com/questions/49085737/geth-ecrecover-invalid-signature-recovery-id
-	data := []byte(m.String())
-	msg := fmt.Sprintf("\x19Ethereum Signed Message:\n%d%s", len(data), data)
-	return crypto.Keccak256Hash([]byte(msg))
+	message := m.PrepareMessageBytes()
+
+	// Keccak256Hash hashes the concatenation of its arguments, so passing the
+	// prefix and message separately avoids copying them into a single buffer
+	prefix := strconv.AppendInt([]byte(_EIP191_PREFIX), int64(len(message)), 10)
+	return crypto.Keccak256Hash(prefix, message)
+}
+
+// SignHash returns the EIP-191 personal_sign digest of the message, which is the
+// value wallets sign and signatures are recovered against.
+func (m *Message) SignHash() common.Hash {
+	return m.eip191Hash()
+}
+
+// Sign produces an EIP-191 signature of the message with the given private key,
+// encoded as a 0x-prefixed hex string with a recovery id of 27 or 28.
+func Sign(message *Message, privateKey *ecdsa.PrivateKey) (string, error) {
+	signature, err := crypto.Sign(message.eip191Hash().Bytes(), privateKey)
+	if err != nil {
+		return "", err
+	}
+
+	signature[64] += 27
+	return "0x" + hex.EncodeToString(signature), nil
+}
+
+// FormatPersonalSignRequest returns the params of a personal_sign JSON-RPC
+// request for the message, as sent to wallets e.g. over WalletConnect: the
+// hex-encoded message followed by the signing address.
+func (m *Message) FormatPersonalSignRequest() []interface{} {
+	return []interface{}{
+		"0x" + hex.EncodeToString(m.PrepareMessageBytes()),
+		m.address.Hex(),
+	}
 }
 
 // ValidNow validates the time constraints of the message at current time.
@@ -249,135 +581,597 @@ func (m *Message) ValidNow() (bool, error) {
 	return m.ValidAt(time.Now().UTC())
 }
 
+// IsExpired reports whether the message expiration time has passed.
+func (m *Message) IsExpired() bool {
+	return m.IsExpiredAt(time.Now().UTC())
+}
+
+// IsExpiredAt reports whether the message expiration time has passed at a specific point in time.
+// Messages without an expiration time never expire.
+func (m *Message) IsExpiredAt(when time.Time) bool {
+	expirationTime := m.getExpirationTime()
+	return expirationTime != nil && when.After(*expirationTime)
+}
+
+// ExpiresIn returns the time left until the message expires, negative if it
+// already has. The bool is false if the message has no expiration time.
+func (m *Message) ExpiresIn() (time.Duration, bool) {
+	return m.ExpiresInAt(time.Now().UTC())
+}
+
+// ExpiresInAt is like ExpiresIn, measuring from a specific point in time.
+func (m *Message) ExpiresInAt(when time.Time) (time.Duration, bool) {
+	expirationTime := m.getExpirationTime()
+	if expirationTime == nil {
+		return 0, false
+	}
+	return expirationTime.Sub(when), true
+}
+
+// NotYetValid reports whether the message not-before time is still in the future.
+func (m *Message) NotYetValid() bool {
+	return m.NotYetValidAt(time.Now().UTC())
+}
+
+// NotYetValidAt reports whether the message not-before time is after a specific point in time.
+// Messages without a not-before time are always valid.
+func (m *Message) NotYetValidAt(when time.Time) bool {
+	notBefore := m.getNotBefore()
+	return notBefore != nil && when.Before(*notBefore)
+}
+
 // ValidAt validates the time constraints of the message at a specific point in time.
 func (m *Message) ValidAt(when time.Time) (bool, error) {
-	if m.expirationTime != nil {
-		if when.After(*m.getExpirationTime()) {
-			return false, &ExpiredMessage{"Message expired"}
-		}
+	return m.validAt(when, 0)
+}
+
+// validAt validates the time constraints, widening both boundaries by skew.
+func (m *Message) validAt(when time.Time, skew time.Duration) (bool, error) {
+	if m.IsExpiredAt(when.Add(-skew)) {
+		return false, &ExpiredMessage{"Message expired"}
 	}
 
-	if m.notBefore != nil {
-		if when.Before(*m.getNotBefore()) {
-			return false, &InvalidMessage{"Message not yet valid"}
-		}
+	if m.NotYetValidAt(when.Add(skew)) {
+		return false, &NotYetValidMessage{"Message not yet valid"}
 	}
 
 	return true, nil
 }
 
-// VerifyEIP191 validates the integrity of the object by matching it's signature.
-func (m *Message) VerifyEIP191(signature string) (*ecdsa.PublicKey, error) {
-	if isEmpty(&signature) {
+func (m *Message) recoverSigner(signature string) (*ecdsa.PublicKey, error) {
+	return recoverSignerFromHash(m.eip191Hash(), signature)
+}
+
+// verifySigner recovers the signer of hash and checks it against the message
+// address. The recovered address is returned even if it doesn't match, and is
+// zero if recovery failed.
+func (m *Message) verifySigner(hash common.Hash, signature string) (*ecdsa.PublicKey, common.Address, error) {
+	pkey, err := recoverSignerFromHash(hash, signature)
+	if err != nil {
+		return nil, common.Address{}, err
+	}
+
+	signer := crypto.PubkeyToAddress(*pkey)
+	if signer != m.address {
+		return nil, signer, &InvalidSignature{"Signer address must match message address"}
+	}
+
+	return pkey, signer, nil
+}
+
+func recoverSignerFromHash(hash common.Hash, signature string) (*ecdsa.PublicKey, error) {
+	if isBlank(&signature) {
 		return nil, &InvalidSignature{"Signature cannot be empty"}
 	}
 
-	sigBytes, err := hexutil.Decode(signature)
+	sigBytes, err := hex.DecodeString(strings.TrimPrefix(signature, "0x"))
 	if err != nil {
 		return nil, &InvalidSignature{"Failed to decode signature"}
 	}
 
+	if len(sigBytes) != 65 {
+		return nil, &InvalidSignature{"Signature must be 65 bytes long"}
+	}
+
 	// Ref:https://github.com/ethereum/go-ethereum/blob/55599ee95d4151a2502465e0afc7c47bd1acba77/internal/ethapi/api.go#L442
-	sigBytes[64] %= 27
+	if sigBytes[64] == 27 || sigBytes[64] == 28 {
+		sigBytes[64] -= 27
+	}
 	if sigBytes[64] != 0 && sigBytes[64] != 1 {
 		return nil, &InvalidSignature{"Invalid signature recovery byte"}
 	}
 
-	pkey, err := crypto.SigToPub(m.eip191Hash().Bytes(), sigBytes)
+	pkey, err := crypto.SigToPub(hash.Bytes(), sigBytes)
 	if err != nil {
 		return nil, &InvalidSignature{"Failed to recover public key from signature"}
 	}
 
-	address := crypto.PubkeyToAddress(*pkey)
+	return pkey, nil
+}
 
-	if address != m.address {
-		return nil, &InvalidSignature{"Signer address must match message address"}
+func checkCanonicalSignature(signature string) error {
+	sigBytes, err := hex.DecodeString(strings.TrimPrefix(signature, "0x"))
+	if err != nil {
+		return &InvalidSignature{"Failed to decode signature"}
 	}
 
-	return pkey, nil
+	if len(sigBytes) != 65 {
+		return &InvalidSignature{"Signature must be 65 bytes long"}
+	}
+
+	r := new(big.Int).SetBytes(sigBytes[:32])
+	s := new(big.Int).SetBytes(sigBytes[32:64])
+	if !crypto.ValidateSignatureValues(0, r, s, true) {
+		return &InvalidSignature{"Signature is malleable, S must be in the lower half of the curve order"}
+	}
+
+	return nil
+}
+
+// VerifyEIP191 validates the integrity of the object by matching it's signature.
+func (m *Message) VerifyEIP191(signature string) (*ecdsa.PublicKey, error) {
+	pkey, _, err := m.verifySigner(m.eip191Hash(), signature)
+	return pkey, err
+}
+
+// VerifyPublicKey validates the time constraints of the message at current time and
+// that an already recovered public key belongs to the message address.
+func (m *Message) VerifyPublicKey(publicKey *ecdsa.PublicKey) error {
+	if publicKey == nil {
+		return &InvalidSignature{"Public key cannot be empty"}
+	}
+
+	if _, err := m.ValidNow(); err != nil {
+		return err
+	}
+
+	if crypto.PubkeyToAddress(*publicKey) != m.address {
+		return &InvalidSignature{"Signer address must match message address"}
+	}
+
+	return nil
+}
+
+// VerifySignerAddress reports whether the signature of the message was produced
+// by the expected address, regardless of the message address.
+func (m *Message) VerifySignerAddress(signature string, expected common.Address) (bool, error) {
+	pkey, err := m.recoverSigner(signature)
+	if err != nil {
+		return false, err
+	}
+
+	return crypto.PubkeyToAddress(*pkey) == expected, nil
+}
+
+// webURISchemes is the default of AllowedURISchemes. It is never handed out,
+// so callers can't widen the default allowlist.
+var webURISchemes = []string{"http", "https"}
+
+// WebURISchemes returns the schemes of web origins, the default of
+// AllowedURISchemes. Each call returns a new slice.
+func WebURISchemes() []string {
+	return append([]string(nil), webURISchemes...)
+}
+
+// VerifyOptions holds the values a server expects a message to be bound to.
+// Nil fields are not checked.
+type VerifyOptions struct {
+	// Domain is the expected value of the message domain.
+	Domain *string
+	// AllowedDomains lists acceptable message domains, compared case-insensitively.
+	AllowedDomains []string
+	// Nonce is the expected value of the message nonce, as issued by the server.
+	Nonce *string
+	// Timestamp is the point in time at which time constraints are evaluated,
+	// it takes precedence over Clock.
+	Timestamp *time.Time
+	// Clock returns the current time, by default time.Now is used.
+	Clock func() time.Time
+	// ContractCaller enables EIP-1271 verification of smart contract wallets
+	// when the signature doesn't recover to the message address. Contract wallets
+	// have no key pair, so a signature they accept is verified with a nil public key.
+	ContractCaller bind.ContractCaller
+	// EIP1271Cache, when set, skips contract calls for signatures a contract
+	// wallet already accepted.
+	EIP1271Cache EIP1271Cache
+	// RequireChecksumAddress rejects messages whose address wasn't provided in EIP-55 form.
+	// The signer is always compared by address bytes, so without it lowercase
+	// addresses are accepted.
+	RequireChecksumAddress bool
+	// ClockSkew tolerates client and server clocks differing by up to this duration
+	// when evaluating time constraints.
+	ClockSkew time.Duration
+	// MaxIssuedAtAge rejects messages issued longer than this ago, or issued in the future.
+	MaxIssuedAtAge time.Duration
+	// RequireExpiration rejects messages without an expiration time.
+	RequireExpiration bool
+	// MaxValidity rejects messages expiring further than this in the future.
+	MaxValidity time.Duration
+	// AllowEIP712 accepts signatures over the EIP-712 typed data representation
+	// of the message when personal_sign recovery fails.
+	AllowEIP712 bool
+	// ForbidStatement rejects messages carrying a statement.
+	ForbidStatement bool
+	// Hook, when set, is notified of each verification step. VerifyBatch
+	// calls it from several goroutines, so it must be safe for concurrent use.
+	Hook VerifyHook
+	// RequireDomainMatchesURIHost rejects messages whose domain differs from
+	// the host of their URI, the port is only compared if the domain has one.
+	RequireDomainMatchesURIHost bool
+	// RequireASCIIDomain rejects domains with non-ASCII characters, which may be
+	// homoglyphs of a trusted domain. Internationalized domains must be punycode.
+	RequireASCIIDomain bool
+	// RequireCanonicalSignature rejects signatures that aren't 65 bytes long or
+	// whose S value is in the upper half of the curve order, so a signature
+	// can't be altered into another valid one.
+	RequireCanonicalSignature bool
+	// RequireNotBefore rejects messages without a not before time.
+	RequireNotBefore bool
+	// AllowedChainIDs lists acceptable message chain IDs, any chain is accepted if empty.
+	AllowedChainIDs []int
+	// RequiredResources lists resource URIs the message must all grant, compared exactly.
+	RequiredResources []string
+	// AllowedURISchemes lists acceptable schemes of the message URI, compared
+	// case-insensitively. Defaults to WebURISchemes if empty, so messages bound
+	// to other URIs, e.g. did: or native app schemes, must be allowed explicitly.
+	AllowedURISchemes []string
+	// AllowAnyURIScheme disables the URI scheme check, e.g. for messages
+	// bound to a DID or a native application.
+	AllowAnyURIScheme bool
 }
 
-// Verify validates time constraints and integrity of the object by matching it's signature.
+func (opts *VerifyOptions) now() time.Time {
+	if opts.Timestamp != nil {
+		return *opts.Timestamp
+	}
+	if opts.Clock != nil {
+		return opts.Clock().UTC()
+	}
+	return time.Now().UTC()
+}
+
+// Verify validates time constraints and integrity of the object by matching it's signature,
+// returning the public key of the signer on success. Unlike VerifyWithOptions it
+// accepts any URI scheme, as it always has.
 func (m *Message) Verify(signature string, domain *string, nonce *string, timestamp *time.Time) (*ecdsa.PublicKey, error) {
-	var err error
+	return m.VerifyWithOptions(signature, VerifyOptions{
+		Domain:            domain,
+		Nonce:             nonce,
+		Timestamp:         timestamp,
+		AllowAnyURIScheme: true,
+	})
+}
 
-	if timestamp != nil {
-		_, err = m.ValidAt(*timestamp)
-	} else {
-		_, err = m.ValidNow()
+func (m *Message) checkExpiration(now time.Time, opts *VerifyOptions) error {
+	expirationTime := m.getExpirationTime()
+	if expirationTime == nil {
+		if opts.RequireExpiration {
+			return &InvalidMessage{"Message must have an expiration time"}
+		}
+		return nil
+	}
+
+	if opts.MaxValidity > 0 && expirationTime.Sub(now) > opts.MaxValidity {
+		return &InvalidMessage{"Message expiration time is too far in the future"}
 	}
 
+	return nil
+}
+
+func (m *Message) checkFreshness(now time.Time, opts *VerifyOptions) error {
+	if opts.MaxIssuedAtAge <= 0 {
+		return nil
+	}
+
+	issuedAt, _, err := m.ParseIssuedAt()
 	if err != nil {
-		return nil, err
+		return &MalformedMessage{"Invalid format for field `issuedAt`"}
+	}
+
+	if issuedAt.After(now.Add(opts.ClockSkew)) {
+		return &InvalidMessage{"Message issuance time is in the future"}
+	}
+
+	if now.Sub(issuedAt) > opts.MaxIssuedAtAge {
+		return &InvalidMessage{"Message was issued too long ago"}
+	}
+
+	return nil
+}
+
+func (m *Message) domainMatchesURIHost() bool {
+	if _, _, err := net.SplitHostPort(m.domain); err == nil {
+		return strings.EqualFold(m.uri.Host, m.domain)
 	}
+	return strings.EqualFold(m.uri.Hostname(), strings.Trim(m.domain, "[]"))
+}
 
-	if domain != nil {
-		if m.GetDomain() != *domain {
-			return nil, &InvalidSignature{"Message domain doesn't match"}
+func (m *Message) chainAllowed(chainIDs []int) bool {
+	for _, chainID := range chainIDs {
+		if m.chainID == chainID {
+			return true
 		}
 	}
+	return false
+}
 
-	if nonce != nil {
-		if m.GetNonce() != *nonce {
-			return nil, &InvalidSignature{"Message nonce doesn't match"}
+// missingResource returns the first required resource the message doesn't grant.
+func (m *Message) missingResource(required []string) (string, bool) {
+	for _, resource := range required {
+		found := false
+		for _, granted := range m.resources {
+			if granted.String() == resource {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return resource, false
 		}
 	}
+	return "", true
+}
 
-	return m.VerifyEIP191(signature)
+func (opts *VerifyOptions) uriSchemes() []string {
+	if len(opts.AllowedURISchemes) == 0 {
+		return webURISchemes
+	}
+	return opts.AllowedURISchemes
 }
 
-func (m *Message) prepareMessage() string {
-	greeting := fmt.Sprintf("%s wants you to sign in with your Ethereum account:", m.domain)
-	headerArr := []string{greeting, m.address.String()}
+func (m *Message) uriSchemeAllowed(schemes []string) bool {
+	for _, scheme := range schemes {
+		if strings.EqualFold(m.uri.Scheme, scheme) {
+			return true
+		}
+	}
+	return false
+}
 
-	if isEmpty(m.statement) {
-		headerArr = append(headerArr, "\n")
-	} else {
-		headerArr = append(headerArr, fmt.Sprintf("\n%s\n", *m.statement))
+func (m *Message) domainAllowed(domains []string) bool {
+	for _, domain := range domains {
+		if strings.EqualFold(m.domain, domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyWithOptions validates time constraints, the expected domain and nonce, and
+// integrity of the object by matching it's signature, returning the public key of
+// the signer on success. The key is nil, with a nil error, when opts.ContractCaller
+// is set and the signature was accepted by a contract wallet.
+func (m *Message) VerifyWithOptions(signature string, opts VerifyOptions) (*ecdsa.PublicKey, error) {
+	return m.VerifyContext(context.Background(), signature, opts)
+}
+
+// VerifyContext is like VerifyWithOptions, threading ctx through any on-chain
+// calls performed during verification. As with VerifyWithOptions, the public key
+// is nil for signatures accepted by a contract wallet.
+func (m *Message) VerifyContext(ctx context.Context, signature string, opts VerifyOptions) (*ecdsa.PublicKey, error) {
+	pkey, err := m.verifyContext(ctx, signature, &opts)
+	opts.emit(VerifyEvent{Stage: StageDone, Message: m, Err: err})
+	return pkey, err
+}
+
+func (m *Message) verifyContext(ctx context.Context, signature string, opts *VerifyOptions) (*ecdsa.PublicKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
 
-	header := strings.Join(headerArr, "\n")
+	now := opts.now()
+	if _, err := m.validAt(now, opts.ClockSkew); err != nil {
+		return nil, err
+	}
 
-	uri := fmt.Sprintf("URI: %s", m.uri.String())
-	version := fmt.Sprintf("Version: %s", m.version)
-	chainId := fmt.Sprintf("Chain ID: %d", m.chainID)
-	nonce := fmt.Sprintf("Nonce: %s", m.nonce)
-	issuedAt := fmt.Sprintf("Issued At: %s", m.issuedAt)
+	if err := m.checkExpiration(now, opts); err != nil {
+		return nil, err
+	}
 
-	bodyArr := []string{uri, version, chainId, nonce, issuedAt}
+	if err := m.checkFreshness(now, opts); err != nil {
+		return nil, err
+	}
 
-	if !isEmpty(m.expirationTime) {
-		value := fmt.Sprintf("Expiration Time: %s", *m.expirationTime)
-		bodyArr = append(bodyArr, value)
+	if opts.RequireNotBefore && m.getNotBefore() == nil {
+		return nil, &InvalidMessage{"Message must have a not before time"}
 	}
 
-	if !isEmpty(m.notBefore) {
-		value := fmt.Sprintf("Not Before: %s", *m.notBefore)
-		bodyArr = append(bodyArr, value)
+	if opts.ForbidStatement && !isBlank(m.statement) {
+		return nil, &InvalidMessage{"Message must not have a statement"}
 	}
 
-	if !isEmpty(m.requestID) {
-		value := fmt.Sprintf("Request ID: %s", *m.requestID)
-		bodyArr = append(bodyArr, value)
+	if opts.RequireChecksumAddress && m.rawAddress != "" && m.rawAddress != m.address.Hex() {
+		return nil, &InvalidMessage{"Address was not provided in EIP-55 format"}
 	}
 
-	if len(m.resources) > 0 {
-		resourcesArr := make([]string, len(m.resources))
-		for i, v := range m.resources {
-			resourcesArr[i] = fmt.Sprintf("- %s", v.String())
+	if opts.Domain != nil {
+		if m.GetDomain() != *opts.Domain {
+			return nil, &DomainMismatch{"Message domain doesn't match"}
+		}
+	}
+
+	if opts.RequireASCIIDomain && !isASCII(m.domain) {
+		return nil, &InvalidMessage{"Domain must only contain ASCII characters, use punycode for internationalized domains"}
+	}
+
+	if opts.RequireDomainMatchesURIHost && !m.domainMatchesURIHost() {
+		return nil, &DomainMismatch{"Message domain doesn't match URI host"}
+	}
+
+	if len(opts.AllowedDomains) > 0 && !m.domainAllowed(opts.AllowedDomains) {
+		return nil, &DomainMismatch{"Message domain doesn't match"}
+	}
+
+	if len(opts.AllowedChainIDs) > 0 && !m.chainAllowed(opts.AllowedChainIDs) {
+		return nil, &InvalidMessage{fmt.Sprintf("Chain ID %d is not allowed", m.chainID)}
+	}
+
+	if !opts.AllowAnyURIScheme && !m.uriSchemeAllowed(opts.uriSchemes()) {
+		return nil, &InvalidMessage{fmt.Sprintf("URI scheme `%s` is not allowed", m.uri.Scheme)}
+	}
+
+	if missing, ok := m.missingResource(opts.RequiredResources); !ok {
+		return nil, &InvalidMessage{fmt.Sprintf("Message is missing required resource `%s`", missing)}
+	}
+
+	if opts.Nonce != nil {
+		if m.GetNonce() != *opts.Nonce {
+			return nil, &NonceMismatch{"Message nonce doesn't match"}
+		}
+	}
+
+	if opts.RequireCanonicalSignature {
+		if err := checkCanonicalSignature(signature); err != nil {
+			return nil, err
+		}
+	}
+
+	hash := m.eip191Hash()
+	opts.emit(VerifyEvent{Stage: StageHashComputed, Message: m, Hash: hash})
+
+	// The hook sees the recovered signer, even when it doesn't match
+	pkey, signer, err := m.verifySigner(hash, signature)
+	if signer != (common.Address{}) {
+		opts.emit(VerifyEvent{Stage: StageSignerRecovered, Message: m, Signer: signer})
+	}
+	if err != nil && opts.AllowEIP712 {
+		if typedHash, hashErr := m.eip712Hash(); hashErr == nil {
+			typedPkey, typedSigner, typedErr := m.verifySigner(typedHash, signature)
+			if typedSigner != (common.Address{}) {
+				opts.emit(VerifyEvent{Stage: StageSignerRecovered, Message: m, Signer: typedSigner})
+			}
+			if typedErr == nil {
+				return typedPkey, nil
+			}
+		}
+	}
+	if err == nil || opts.ContractCaller == nil {
+		return pkey, err
+	}
+
+	// Contract wallets have no key pair, so a successful check yields no public key
+	sigBytes, decodeErr := hex.DecodeString(strings.TrimPrefix(signature, "0x"))
+	if decodeErr != nil {
+		return nil, err
+	}
+
+	var cacheKey EIP1271CacheKey
+	if opts.EIP1271Cache != nil {
+		cacheKey = EIP1271CacheKey{Signer: m.address, Digest: hash, Signature: string(sigBytes)}
+		if opts.EIP1271Cache.Valid(cacheKey) {
+			return nil, nil
 		}
+	}
+
+	isContract, codeErr := m.isContract(ctx, opts.ContractCaller)
+	if codeErr != nil {
+		return nil, codeErr
+	}
+	if !isContract {
+		return nil, err
+	}
+
+	if err := m.VerifyEIP1271(ctx, opts.ContractCaller, sigBytes); err != nil {
+		return nil, err
+	}
+
+	if opts.EIP1271Cache != nil {
+		opts.EIP1271Cache.Store(cacheKey)
+	}
+
+	return nil, nil
+}
+
+// ParseAndVerify parses an EIP-4361 formatted string, validates its structure
+// and verifies the signature against opts, returning the parsed message on success.
+// Use the message address to identify the signer, which may be a contract wallet
+// without a public key when opts.ContractCaller is set.
+func ParseAndVerify(message, signature string, opts VerifyOptions) (*Message, error) {
+	parsed, err := ParseMessage(message)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := parsed.Validate(); err != nil {
+		return nil, err
+	}
+
+	opts.emit(VerifyEvent{Stage: StageParsed, Message: parsed})
+
+	if _, err := parsed.VerifyWithOptions(signature, opts); err != nil {
+		return nil, err
+	}
+
+	return parsed, nil
+}
+
+func (m *Message) writeMessage(buf *bytes.Buffer) {
+	if !isBlank(m.scheme) {
+		buf.WriteString(*m.scheme)
+		buf.WriteString("://")
+	}
+	buf.WriteString(m.domain)
+	buf.WriteString(" wants you to sign in with your Ethereum account:\n")
+	buf.WriteString(m.address.String())
+	buf.WriteString("\n\n")
+
+	if !isBlank(m.statement) {
+		buf.WriteString(*m.statement)
+		buf.WriteString("\n")
+	}
+	buf.WriteString("\n")
+
+	buf.WriteString("URI: ")
+	buf.WriteString(m.uri.String())
+	buf.WriteString("\nVersion: ")
+	buf.WriteString(m.version)
+	buf.WriteString("\nChain ID: ")
+	buf.WriteString(strconv.Itoa(m.chainID))
+	buf.WriteString("\nNonce: ")
+	buf.WriteString(m.nonce)
+	buf.WriteString("\nIssued At: ")
+	buf.WriteString(m.issuedAt)
+
+	if !isBlank(m.expirationTime) {
+		buf.WriteString("\nExpiration Time: ")
+		buf.WriteString(*m.expirationTime)
+	}
 
-		resources := strings.Join(resourcesArr, "\n")
-		value := fmt.Sprintf("Resources:\n%s", resources)
+	if !isBlank(m.notBefore) {
+		buf.WriteString("\nNot Before: ")
+		buf.WriteString(*m.notBefore)
+	}
+
+	if !isBlank(m.requestID) {
+		buf.WriteString("\nRequest ID: ")
+		buf.WriteString(*m.requestID)
+	}
 
-		bodyArr = append(bodyArr, value)
+	if len(m.resources) > 0 {
+		buf.WriteString("\nResources:")
+		for _, resource := range m.resources {
+			buf.WriteString("\n- ")
+			buf.WriteString(resource.String())
+		}
 	}
+}
 
-	body := strings.Join(bodyArr, "\n")
+// PrepareMessageBytes returns the EIP-4361 representation of the message as
+// bytes, avoiding a string conversion when hashing or signing.
+func (m *Message) PrepareMessageBytes() []byte {
+	var buf bytes.Buffer
+	buf.Grow(512)
+	m.writeMessage(&buf)
+	return buf.Bytes()
+}
 
-	return strings.Join([]string{header, body}, "\n")
+func (m *Message) prepareMessage() string {
+	var buf bytes.Buffer
+	m.writeMessage(&buf)
+	return buf.String()
 }
 
+// String returns the EIP-4361 representation of the message, as it is signed by wallets.
 func (m *Message) String() string {
 	return m.prepareMessage()
 }
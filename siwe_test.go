@@ -1,18 +1,31 @@
 package siwe
 
 import (
+	"context"
 	"crypto/ecdsa"
+	cryptorand "crypto/rand"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"math/big"
 	"net/url"
 	"os"
+	"regexp"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
 	"github.com/relvacode/iso8601"
 	"github.com/stretchr/testify/assert"
 )
@@ -27,7 +40,7 @@ const version = "1"
 const statement = "Example statement for SIWE"
 
 var issuedAt = time.Now().UTC().Format(time.RFC3339)
-var nonce = GenerateNonce()
+var nonce = MustGenerateNonce()
 
 const chainId = 1
 
@@ -69,6 +82,7 @@ var message, _ = InitMessage(
 )
 
 func compareMessage(t *testing.T, a, b *Message) {
+	assert.Equal(t, a.scheme, b.scheme, "expected %s, found %s", a.scheme, b.scheme)
 	assert.Equal(t, a.domain, b.domain, "expected %s, found %s", a.domain, b.domain)
 	assert.Equal(t, a.address, b.address, "expected %s, found %s", a.address, b.address)
 	assert.Equal(t, a.uri.String(), b.uri.String(), "expected %s, found %s", a.uri, b.uri)
@@ -114,7 +128,7 @@ func TestCreate(t *testing.T) {
 }
 
 func TestCreateRequired(t *testing.T) {
-	message, err := InitMessage(domain, addressStr, uri, GenerateNonce(), map[string]interface{}{})
+	message, err := InitMessage(domain, addressStr, uri, MustGenerateNonce(), map[string]interface{}{})
 	assert.Nil(t, err)
 
 	assert.Equal(t, message.domain, domain, "domain should be %s", domain)
@@ -137,13 +151,13 @@ func TestCreateRequired(t *testing.T) {
 func TestCreateEmpty(t *testing.T) {
 	var err error
 
-	_, err = InitMessage("", addressStr, uri, GenerateNonce(), map[string]interface{}{})
+	_, err = InitMessage("", addressStr, uri, MustGenerateNonce(), map[string]interface{}{})
 	assert.Error(t, err)
 
-	_, err = InitMessage(domain, "", uri, GenerateNonce(), map[string]interface{}{})
+	_, err = InitMessage(domain, "", uri, MustGenerateNonce(), map[string]interface{}{})
 	assert.Error(t, err)
 
-	_, err = InitMessage(domain, addressStr, "", GenerateNonce(), map[string]interface{}{})
+	_, err = InitMessage(domain, addressStr, "", MustGenerateNonce(), map[string]interface{}{})
 	assert.Error(t, err)
 
 	_, err = InitMessage(domain, addressStr, uri, "", map[string]interface{}{})
@@ -160,7 +174,7 @@ func TestPrepareParse(t *testing.T) {
 }
 
 func TestPrepareParseRequired(t *testing.T) {
-	message, err := InitMessage(domain, addressStr, uri, GenerateNonce(), map[string]interface{}{})
+	message, err := InitMessage(domain, addressStr, uri, MustGenerateNonce(), map[string]interface{}{})
 	assert.Nil(t, err)
 
 	prepare := message.String()
@@ -190,10 +204,62 @@ func createWallet(t *testing.T) (*ecdsa.PrivateKey, string) {
 	return privateKey, address
 }
 
+// signer signs the messages built by signedMessage.
+var signer, _ = crypto.GenerateKey()
+var signerAddress = crypto.PubkeyToAddress(signer.PublicKey).Hex()
+
+// signedMessage builds a message for signerAddress from the test fixtures and
+// signs it. fields may override `domain`, `address` and `uri`, other fields
+// are passed to InitMessage as options.
+func signedMessage(t *testing.T, fields map[string]interface{}) (*Message, string) {
+	t.Helper()
+
+	args := map[string]string{"domain": domain, "address": signerAddress, "uri": uri}
+	options := make(map[string]interface{})
+	for key, value := range fields {
+		if _, ok := args[key]; ok {
+			args[key] = value.(string)
+		} else {
+			options[key] = value
+		}
+	}
+
+	message, err := InitMessage(args["domain"], args["address"], args["uri"], nonce, options)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	signature, err := Sign(message, signer)
+	assert.Nil(t, err)
+
+	return message, signature
+}
+
+// verifyOptionsCase is a signedMessage verified with opts, expecting err.
+type verifyOptionsCase struct {
+	name   string
+	fields map[string]interface{}
+	opts   VerifyOptions
+	err    error
+}
+
+func testVerifyOptions(t *testing.T, cases []verifyOptionsCase) {
+	t.Helper()
+
+	for _, c := range cases {
+		message, signature := signedMessage(t, c.fields)
+		_, err := message.VerifyWithOptions(signature, c.opts)
+		if c.err == nil {
+			assert.Nil(t, err, c.name)
+		} else {
+			assert.Equal(t, c.err, err, c.name)
+		}
+	}
+}
+
 func TestValidateNotBefore(t *testing.T) {
 	privateKey, address := createWallet(t)
 
-	message, err := InitMessage(domain, address, uri, GenerateNonce(), map[string]interface{}{
+	message, err := InitMessage(domain, address, uri, MustGenerateNonce(), map[string]interface{}{
 		"notBefore": time.Now().UTC().Add(24 * time.Hour).Format(time.RFC3339),
 	})
 	assert.Nil(t, err)
@@ -207,14 +273,14 @@ func TestValidateNotBefore(t *testing.T) {
 	_, err = message.Verify(hexutil.Encode(signature), nil, nil, nil)
 
 	if assert.Error(t, err) {
-		assert.Equal(t, &InvalidMessage{"Message not yet valid"}, err)
+		assert.Equal(t, &NotYetValidMessage{"Message not yet valid"}, err)
 	}
 }
 
 func TestValidateExpirationTime(t *testing.T) {
 	privateKey, address := createWallet(t)
 
-	message, err := InitMessage(domain, address, uri, GenerateNonce(), map[string]interface{}{
+	message, err := InitMessage(domain, address, uri, MustGenerateNonce(), map[string]interface{}{
 		"expirationTime": time.Now().UTC().Add(-24 * time.Hour).Format(time.RFC3339),
 	})
 	assert.Nil(t, err)
@@ -426,3 +492,2396 @@ func TestGlobalTestVector(t *testing.T) {
 		}
 	}
 }
+
+func TestGenerateNonce(t *testing.T) {
+	pattern := regexp.MustCompile("^[a-zA-Z0-9]{8,}$")
+	seen := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		nonce, err := GenerateNonce()
+		assert.Nil(t, err)
+		assert.Len(t, nonce, 16)
+		assert.Regexp(t, pattern, nonce)
+		assert.False(t, seen[nonce], "nonce %s generated twice", nonce)
+		seen[nonce] = true
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, errors.New("entropy source unavailable")
+}
+
+func TestGenerateNonceRandFailure(t *testing.T) {
+	randReader = failingReader{}
+	defer func() { randReader = cryptorand.Reader }()
+
+	nonce, err := GenerateNonce()
+	assert.EqualError(t, err, "entropy source unavailable")
+	assert.Empty(t, nonce)
+
+	_, err = GenerateReadableNonce(16)
+	assert.EqualError(t, err, "entropy source unavailable")
+
+	_, err = NewMessage(domain, addressStr, uri, "", MessageOptions{})
+	assert.EqualError(t, err, "entropy source unavailable")
+
+	assert.PanicsWithValue(t, "siwe: failed to generate nonce: entropy source unavailable", func() {
+		MustGenerateNonce()
+	})
+}
+
+func TestGenerateNonceN(t *testing.T) {
+	pattern := regexp.MustCompile("^[a-zA-Z0-9]{8,}$")
+	for _, length := range []int{8, 64} {
+		nonce, err := GenerateNonceN(length)
+		assert.Nil(t, err)
+		assert.Len(t, nonce, length)
+		assert.Regexp(t, pattern, nonce)
+	}
+
+	nonce, err := GenerateNonceN(7)
+	assert.Error(t, err)
+	assert.Empty(t, nonce)
+}
+
+func TestPrepareGreeting(t *testing.T) {
+	prepare := message.String()
+	assert.True(t, strings.HasPrefix(prepare, fmt.Sprintf("%s wants you to sign in with your Ethereum account:\n", domain)))
+
+	parse, err := ParseMessage(prepare)
+	assert.Nil(t, err)
+	compareMessage(t, message, parse)
+}
+
+func TestPrepareResources(t *testing.T) {
+	prepare := message.String()
+	assert.Contains(t, prepare, "\nResources:\n- https://example.com/resources/1\n- https://example.com/resources/2")
+
+	message, err := InitMessage(domain, addressStr, uri, MustGenerateNonce(), map[string]interface{}{})
+	assert.Nil(t, err)
+	assert.NotContains(t, message.String(), "Resources:")
+}
+
+func TestPrepareParseResources(t *testing.T) {
+	claim, _ := url.Parse("https://example.com/my-web2-claim.json")
+	message, err := InitMessage(domain, addressStr, uri, MustGenerateNonce(), map[string]interface{}{
+		"resources": []url.URL{*claim},
+	})
+	assert.Nil(t, err)
+
+	prepare := message.String()
+	assert.Contains(t, prepare, "\n- https://example.com/my-web2-claim.json")
+
+	parse, err := ParseMessage(prepare)
+	assert.Nil(t, err)
+	assert.Equal(t, []url.URL{*claim}, parse.GetResources())
+}
+
+func TestParseMalformed(t *testing.T) {
+	prepare := message.String()
+	cases := map[string]string{
+		"empty":       "",
+		"garbage":     "this is not a SIWE message",
+		"truncated":   prepare[:len(prepare)/2],
+		"missing uri": strings.Replace(prepare, fmt.Sprintf("URI: %s\n", uri), "", 1),
+	}
+
+	for name, input := range cases {
+		assert.NotPanics(t, func() {
+			parsed, err := ParseMessage(input)
+			assert.Nil(t, parsed, name)
+			assert.Error(t, err, name)
+		}, name)
+	}
+}
+
+const walletMessage = "localhost:3000 wants you to sign in with your Ethereum account:\n0x2c7536E3605D9C16a7a3D7b1898e529396a65c23\n\nSign in with Ethereum to the app.\n\nURI: http://localhost:3000/login\nVersion: 1\nChain ID: 1\nNonce: k7bNPyc9Y2H8rZbT\nIssued At: 2022-12-01T12:00:00Z"
+const walletAddress = "0x2c7536E3605D9C16a7a3D7b1898e529396a65c23"
+const walletSignature = "0x406264c946ccd7657d278fcadf28d9a4ff05135991315e3d11c7bb8d43f30b1c146fe8073e0de7149add01d93041a0086a6330e63a1822d77c89c9091188adf51b"
+
+func TestVerifyWalletSignature(t *testing.T) {
+	message, err := ParseMessage(walletMessage)
+	assert.Nil(t, err)
+
+	publicKey, err := message.VerifyEIP191(walletSignature)
+	if assert.Nil(t, err) {
+		assert.Equal(t, walletAddress, crypto.PubkeyToAddress(*publicKey).Hex())
+	}
+
+	_, err = message.VerifyEIP191(strings.TrimPrefix(walletSignature, "0x"))
+	assert.Nil(t, err)
+
+	_, err = message.VerifyEIP191(walletSignature[:len(walletSignature)-2])
+	assert.Equal(t, &InvalidSignature{"Signature must be 65 bytes long"}, err)
+
+	_, err = message.VerifyEIP191("0xnothex")
+	assert.Equal(t, &InvalidSignature{"Failed to decode signature"}, err)
+}
+
+func TestVerifyRecoveryID(t *testing.T) {
+	message, err := ParseMessage(walletMessage)
+	assert.Nil(t, err)
+
+	signature, err := hexutil.Decode(walletSignature)
+	assert.Nil(t, err)
+
+	_, err = message.VerifyEIP191(hexutil.Encode(signature))
+	assert.Nil(t, err)
+
+	signature[64] -= 27
+	_, err = message.VerifyEIP191(hexutil.Encode(signature))
+	assert.Nil(t, err)
+
+	signature[64] += 54
+	_, err = message.VerifyEIP191(hexutil.Encode(signature))
+	assert.Equal(t, &InvalidSignature{"Invalid signature recovery byte"}, err)
+}
+
+func TestVerifyPersonalSignPrefix(t *testing.T) {
+	privateKey, address := createWallet(t)
+
+	message, err := InitMessage(domain, address, uri, nonce, options)
+	assert.Nil(t, err)
+
+	data := []byte(message.String())
+	prefixed := fmt.Sprintf("\x19Ethereum Signed Message:\n%d%s", len(data), data)
+	signature, err := crypto.Sign(crypto.Keccak256([]byte(prefixed)), privateKey)
+	assert.Nil(t, err)
+
+	_, err = message.VerifyEIP191(hexutil.Encode(signature))
+	assert.Nil(t, err)
+
+	signature, err = crypto.Sign(crypto.Keccak256(data), privateKey)
+	assert.Nil(t, err)
+
+	_, err = message.VerifyEIP191(hexutil.Encode(signature))
+	assert.Error(t, err)
+}
+
+func TestCreateAddressCase(t *testing.T) {
+	privateKey, address := createWallet(t)
+	hex := strings.TrimPrefix(address, "0x")
+
+	for _, variant := range []string{address, "0x" + strings.ToLower(hex), "0x" + strings.ToUpper(hex)} {
+		message, err := InitMessage(domain, variant, uri, nonce, options)
+		assert.Nil(t, err, variant)
+
+		signature, err := crypto.Sign(message.eip191Hash().Bytes(), privateKey)
+		assert.Nil(t, err)
+
+		_, err = message.VerifyEIP191(hexutil.Encode(signature))
+		assert.Nil(t, err, variant)
+	}
+
+	_, err := InitMessage(domain, "0x71c7656EC7ab88b098defB751B7401B5f6d8976F", uri, nonce, options)
+	assert.Equal(t, &MalformedMessage{"Address has an invalid EIP-55 checksum"}, err)
+
+	_, err = InitMessage(domain, "0x71C7656EC7ab88b098defB751B7401B5f6d8976", uri, nonce, options)
+	assert.Equal(t, &MalformedMessage{"Invalid format for field `address`"}, err)
+}
+
+func TestParseNonHexAddress(t *testing.T) {
+	prepare := strings.Replace(message.String(), addressStr, "0x71C7656EC7ab88b098defB751B7401B5f6d8976z", 1)
+
+	_, err := ParseMessage(prepare)
+	assert.Equal(t, &ParseError{"address"}, err)
+}
+
+func TestVerifyWithOptions(t *testing.T) {
+	message, err := ParseMessage(walletMessage)
+	assert.Nil(t, err)
+
+	expectedDomain := "localhost:3000"
+	expectedNonce := "k7bNPyc9Y2H8rZbT"
+
+	_, err = message.VerifyWithOptions(walletSignature, VerifyOptions{
+		Domain: &expectedDomain,
+		Nonce:  &expectedNonce,
+	})
+	assert.Nil(t, err)
+
+	otherNonce := MustGenerateNonce()
+	_, err = message.VerifyWithOptions(walletSignature, VerifyOptions{
+		Domain: &expectedDomain,
+		Nonce:  &otherNonce,
+	})
+	assert.Equal(t, &NonceMismatch{"Message nonce doesn't match"}, err)
+
+	otherDomain := "phishing.example"
+	_, err = message.VerifyWithOptions(walletSignature, VerifyOptions{
+		Domain: &otherDomain,
+		Nonce:  &expectedNonce,
+	})
+	assert.Equal(t, &DomainMismatch{"Message domain doesn't match"}, err)
+}
+
+func TestVerifyClock(t *testing.T) {
+	privateKey, address := createWallet(t)
+	expiration := time.Date(2022, 12, 1, 12, 0, 0, 0, time.UTC)
+
+	message, err := InitMessage(domain, address, uri, nonce, map[string]interface{}{
+		"issuedAt":       expiration.Add(-time.Hour),
+		"expirationTime": expiration,
+	})
+	assert.Nil(t, err)
+
+	signature, err := crypto.Sign(message.eip191Hash().Bytes(), privateKey)
+	assert.Nil(t, err)
+
+	before := func() time.Time { return expiration.Add(-time.Minute) }
+	_, err = message.VerifyWithOptions(hexutil.Encode(signature), VerifyOptions{Clock: before})
+	assert.Nil(t, err)
+
+	after := func() time.Time { return expiration.Add(time.Minute) }
+	_, err = message.VerifyWithOptions(hexutil.Encode(signature), VerifyOptions{Clock: after})
+	assert.Equal(t, &ExpiredMessage{"Message expired"}, err)
+
+	// An explicit timestamp takes precedence over the clock
+	at := before()
+	_, err = message.VerifyWithOptions(hexutil.Encode(signature), VerifyOptions{Timestamp: &at, Clock: after})
+	assert.Nil(t, err)
+}
+
+func TestIsExpired(t *testing.T) {
+	expired, err := InitMessage(domain, addressStr, uri, nonce, map[string]interface{}{
+		"expirationTime": time.Now().UTC().Add(-time.Hour),
+	})
+	assert.Nil(t, err)
+	assert.True(t, expired.IsExpired())
+
+	valid, err := InitMessage(domain, addressStr, uri, nonce, map[string]interface{}{
+		"expirationTime": time.Now().UTC().Add(time.Hour),
+	})
+	assert.Nil(t, err)
+	assert.False(t, valid.IsExpired())
+	assert.True(t, valid.IsExpiredAt(time.Now().UTC().Add(2*time.Hour)))
+
+	unbounded, err := InitMessage(domain, addressStr, uri, nonce, map[string]interface{}{})
+	assert.Nil(t, err)
+	assert.False(t, unbounded.IsExpired())
+}
+
+func TestNotYetValid(t *testing.T) {
+	notBefore := time.Now().UTC().Add(time.Hour)
+	message, err := InitMessage(domain, addressStr, uri, nonce, map[string]interface{}{
+		"notBefore": notBefore,
+	})
+	assert.Nil(t, err)
+	assert.True(t, message.NotYetValid())
+	assert.True(t, message.NotYetValidAt(notBefore.Add(-time.Minute)))
+	assert.False(t, message.NotYetValidAt(notBefore.Add(time.Minute)))
+
+	unbounded, err := InitMessage(domain, addressStr, uri, nonce, map[string]interface{}{})
+	assert.Nil(t, err)
+	assert.False(t, unbounded.NotYetValid())
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	data, err := json.Marshal(message)
+	assert.Nil(t, err)
+
+	var decoded Message
+	err = json.Unmarshal(data, &decoded)
+	assert.Nil(t, err)
+
+	compareMessage(t, message, &decoded)
+	assert.Equal(t, message.String(), decoded.String())
+}
+
+func TestJSONMarshalValue(t *testing.T) {
+	expected, err := json.Marshal(message)
+	assert.Nil(t, err)
+
+	data, err := json.Marshal(*message)
+	assert.Nil(t, err)
+	assert.JSONEq(t, string(expected), string(data))
+
+	// Messages embedded by value are encoded the same way
+	data, err = json.Marshal(struct{ Message Message }{*message})
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"Message":`+string(expected)+`}`, string(data))
+}
+
+func TestJSONRoundTripRequired(t *testing.T) {
+	message, err := InitMessage(domain, addressStr, uri, MustGenerateNonce(), map[string]interface{}{})
+	assert.Nil(t, err)
+
+	data, err := json.Marshal(message)
+	assert.Nil(t, err)
+
+	var decoded Message
+	err = json.Unmarshal(data, &decoded)
+	assert.Nil(t, err)
+
+	compareMessage(t, message, &decoded)
+}
+
+func TestStringer(t *testing.T) {
+	var stringer fmt.Stringer = message
+	assert.Equal(t, message.prepareMessage(), stringer.String())
+	assert.Equal(t, message.String(), fmt.Sprintf("%v", message))
+}
+
+func TestParseErrorSection(t *testing.T) {
+	prepare := message.String()
+	cases := map[string]string{
+		"domain":         strings.Replace(prepare, "wants you to sign in", "wants you to sign", 1),
+		"uri":            strings.Replace(prepare, fmt.Sprintf("URI: %s\n", uri), "", 1),
+		"version":        strings.Replace(prepare, "Version: 1", "Version: one", 1),
+		"chainId":        strings.Replace(prepare, "Chain ID: 1", "Chain ID: one", 1),
+		"nonce":          strings.Replace(prepare, fmt.Sprintf("Nonce: %s\n", nonce), "", 1),
+		"issuedAt":       strings.Replace(prepare, "Issued At: ", "Issued At: yesterday", 1),
+		"expirationTime": strings.Replace(prepare, "Expiration Time: ", "Expiration Time: tomorrow", 1),
+	}
+
+	for section, input := range cases {
+		_, err := ParseMessage(input)
+		assert.Equal(t, &ParseError{section}, err, section)
+	}
+}
+
+func TestCreateURI(t *testing.T) {
+	_, err := InitMessage(domain, addressStr, "https://example.com", nonce, map[string]interface{}{})
+	assert.Nil(t, err)
+
+	_, err = InitMessage(domain, addressStr, "/login", nonce, map[string]interface{}{})
+	assert.Equal(t, &MalformedMessage{"Invalid format for field `uri`"}, err)
+
+	_, err = InitMessage(domain, addressStr, "", nonce, map[string]interface{}{})
+	assert.Equal(t, &MalformedMessage{"`uri` must not be empty"}, err)
+}
+
+func TestValidateStructure(t *testing.T) {
+	assert.Nil(t, message.Validate())
+
+	missingDomain := *message
+	missingDomain.domain = ""
+	assert.Equal(t, &MalformedMessage{"`domain` must not be empty"}, missingDomain.Validate())
+
+	badAddress := *message
+	badAddress.address = common.Address{}
+	assert.Equal(t, &MalformedMessage{"`address` must not be empty"}, badAddress.Validate())
+
+	shortNonce := *message
+	shortNonce.nonce = "abc123"
+	assert.Equal(t, &MalformedMessage{"`nonce` must be at least 8 alphanumeric characters"}, shortNonce.Validate())
+
+	assert.Error(t, (&Message{}).Validate())
+}
+
+func TestCreateChainID(t *testing.T) {
+	for value, expected := range map[interface{}]int{"1": 1, "137": 137, 137: 137, float64(10): 10} {
+		message, err := InitMessage(domain, addressStr, uri, nonce, map[string]interface{}{"chainId": value})
+		assert.Nil(t, err)
+		assert.Equal(t, expected, message.GetChainID())
+	}
+
+	for _, value := range []interface{}{"mainnet", 1.5} {
+		_, err := InitMessage(domain, addressStr, uri, nonce, map[string]interface{}{"chainId": value})
+		assert.Equal(t, &MalformedMessage{"Invalid format for field `chainId`, must be an integer"}, err)
+	}
+
+	for _, value := range []interface{}{0, "-1"} {
+		_, err := InitMessage(domain, addressStr, uri, nonce, map[string]interface{}{"chainId": value})
+		assert.Equal(t, &MalformedMessage{"`chainId` must be a positive integer"}, err)
+	}
+}
+
+type mockContractCaller struct {
+	code    []byte
+	codeErr error
+	output  []byte
+}
+
+func (c *mockContractCaller) CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) ([]byte, error) {
+	return c.code, c.codeErr
+}
+
+func (c *mockContractCaller) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	return c.output, nil
+}
+
+func TestVerifyEIP1271(t *testing.T) {
+	message, err := InitMessage(domain, addressStr, uri, nonce, options)
+	assert.Nil(t, err)
+
+	signature := []byte("contract wallet signature")
+	magic := common.RightPadBytes([]byte{0x16, 0x26, 0xba, 0x7e}, 32)
+
+	valid := &mockContractCaller{code: []byte{0x60}, output: magic}
+	assert.Nil(t, message.VerifyEIP1271(context.Background(), valid, signature))
+
+	pkey, err := message.VerifyWithOptions(hexutil.Encode(signature), VerifyOptions{ContractCaller: valid})
+	assert.Nil(t, err)
+	assert.Nil(t, pkey, "contract wallets have no public key")
+
+	// RPC failures must not be mistaken for a signature mismatch
+	outage := &mockContractCaller{codeErr: errors.New("connection refused"), output: magic}
+	_, err = message.VerifyWithOptions(hexutil.Encode(signature), VerifyOptions{ContractCaller: outage})
+	assert.EqualError(t, err, "connection refused")
+
+	invalid := &mockContractCaller{code: []byte{0x60}, output: make([]byte, 32)}
+	assert.Equal(t, &InvalidSignature{"Contract wallet rejected signature"}, message.VerifyEIP1271(context.Background(), invalid, signature))
+
+	_, err = message.VerifyWithOptions(hexutil.Encode(signature), VerifyOptions{ContractCaller: invalid})
+	assert.Equal(t, &InvalidSignature{"Contract wallet rejected signature"}, err)
+
+	// Externally owned accounts don't fall back to EIP-1271
+	eoa := &mockContractCaller{output: magic}
+	_, err = message.VerifyWithOptions(hexutil.Encode(signature), VerifyOptions{ContractCaller: eoa})
+	assert.Equal(t, &InvalidSignature{"Signature must be 65 bytes long"}, err)
+}
+
+func TestSign(t *testing.T) {
+	privateKey, address := createWallet(t)
+
+	message, err := InitMessage(domain, address, uri, nonce, options)
+	assert.Nil(t, err)
+
+	signature, err := Sign(message, privateKey)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(signature, "0x"))
+
+	sigBytes, err := hexutil.Decode(signature)
+	assert.Nil(t, err)
+	assert.Len(t, sigBytes, 65)
+	assert.Contains(t, []byte{27, 28}, sigBytes[64])
+
+	publicKey, err := message.VerifyEIP191(signature)
+	if assert.Nil(t, err) {
+		assert.Equal(t, address, crypto.PubkeyToAddress(*publicKey).Hex())
+	}
+}
+
+func TestVerifyContextCanceled(t *testing.T) {
+	message, err := ParseMessage(walletMessage)
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = message.VerifyContext(ctx, walletSignature, VerifyOptions{})
+	assert.Equal(t, context.Canceled, err)
+
+	_, err = message.VerifyContext(context.Background(), walletSignature, VerifyOptions{})
+	assert.Nil(t, err)
+}
+
+func TestMemoryNonceStore(t *testing.T) {
+	var store NonceStore = NewMemoryNonceStore(time.Minute)
+
+	nonce, err := store.Issue()
+	assert.Nil(t, err)
+	assert.Regexp(t, regexp.MustCompile("^[a-zA-Z0-9]{8,}$"), nonce)
+
+	ok, err := store.Consume(nonce)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	ok, err = store.Consume(nonce)
+	assert.Nil(t, err)
+	assert.False(t, ok, "nonce must not be consumed twice")
+
+	ok, err = store.Consume(MustGenerateNonce())
+	assert.Nil(t, err)
+	assert.False(t, ok, "unknown nonce must be rejected")
+}
+
+func TestMemoryNonceStoreExpiry(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryNonceStore(time.Minute)
+	store.now = func() time.Time { return now }
+
+	expired, err := store.Issue()
+	assert.Nil(t, err)
+
+	now = now.Add(2 * time.Minute)
+	ok, err := store.Consume(expired)
+	assert.Nil(t, err)
+	assert.False(t, ok, "expired nonce must be rejected")
+
+	stale, err := store.Issue()
+	assert.Nil(t, err)
+	now = now.Add(2 * time.Minute)
+	_, err = store.Issue()
+	assert.Nil(t, err)
+	assert.NotContains(t, store.nonces, stale, "expired nonce must be evicted")
+}
+
+func TestMemoryNonceStoreSweepInterval(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryNonceStore(time.Minute)
+	store.now = func() time.Time { return now }
+
+	_, err := store.Issue()
+	assert.Nil(t, err)
+	now = now.Add(30 * time.Second)
+	stale, err := store.Issue()
+	assert.Nil(t, err)
+
+	// Sweeps run at most once per TTL, expired nonces wait for the next one
+	now = now.Add(40 * time.Second)
+	_, err = store.Issue()
+	assert.Nil(t, err)
+	now = now.Add(30 * time.Second)
+	_, err = store.Issue()
+	assert.Nil(t, err)
+	assert.Contains(t, store.nonces, stale)
+
+	ok, err := store.Consume(stale)
+	assert.Nil(t, err)
+	assert.False(t, ok, "expired nonce must be rejected before it's swept")
+
+	now = now.Add(time.Minute)
+	_, err = store.Issue()
+	assert.Nil(t, err)
+	assert.Len(t, store.nonces, 1)
+}
+
+func TestMemoryNonceStoreConcurrent(t *testing.T) {
+	store := NewMemoryNonceStore(time.Minute)
+	nonce, err := store.Issue()
+	assert.Nil(t, err)
+
+	var wg sync.WaitGroup
+	var consumed int32
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ok, _ := store.Consume(nonce); ok {
+				atomic.AddInt32(&consumed, 1)
+			}
+			_, _ = store.Issue()
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), consumed)
+}
+
+func TestPrepareParseScheme(t *testing.T) {
+	withScheme, err := InitMessage(domain, addressStr, uri, nonce, map[string]interface{}{
+		"scheme": "https",
+	})
+	assert.Nil(t, err)
+
+	prepare := withScheme.String()
+	assert.True(t, strings.HasPrefix(prepare, "https://example.com wants you to sign in"))
+
+	parse, err := ParseMessage(prepare)
+	assert.Nil(t, err)
+	assert.Equal(t, "https", *parse.GetScheme())
+	assert.Equal(t, domain, parse.GetDomain())
+	compareMessage(t, withScheme, parse)
+
+	parse, err = ParseMessage(message.String())
+	assert.Nil(t, err)
+	assert.Nil(t, parse.GetScheme())
+
+	_, err = InitMessage(domain, addressStr, uri, nonce, map[string]interface{}{"scheme": "1http"})
+	assert.Equal(t, &MalformedMessage{"Invalid format for field `scheme`"}, err)
+}
+
+func TestParseStrict(t *testing.T) {
+	prepare := message.String()
+
+	strict, err := ParseMessageStrict(prepare)
+	assert.Nil(t, err)
+	compareMessage(t, message, strict)
+
+	required, err := InitMessage(domain, addressStr, uri, MustGenerateNonce(), map[string]interface{}{})
+	assert.Nil(t, err)
+	strict, err = ParseMessageStrict(required.String())
+	assert.Nil(t, err)
+	compareMessage(t, required, strict)
+
+	extraBlankLine := strings.Replace(prepare, statement+"\n", statement+"\n\n", 1)
+	_, err = ParseMessage(extraBlankLine)
+	assert.Error(t, err)
+	_, err = ParseMessageStrict(extraBlankLine)
+	assert.Equal(t, &ParseError{"uri"}, err)
+
+	// Statements are restricted to reserved and unreserved URI characters
+	quoted := strings.Replace(prepare, statement, "\"Example\" statement", 1)
+	_, err = ParseMessage(quoted)
+	assert.Nil(t, err)
+	_, err = ParseMessageStrict(quoted)
+	assert.Equal(t, &ParseError{"statement"}, err)
+
+	malformedExpiration := strings.Replace(prepare, "Expiration Time: ", "Expiration Time: soon", 1)
+	_, err = ParseMessageStrict(malformedExpiration)
+	assert.Equal(t, &ParseError{"expirationTime"}, err)
+}
+
+func TestCreateInvalidResource(t *testing.T) {
+	valid, _ := url.Parse("https://example.com/resources/1")
+	relative := url.URL{Path: "resources/2"}
+
+	_, err := InitMessage(domain, addressStr, uri, nonce, map[string]interface{}{
+		"resources": []url.URL{*valid, relative},
+	})
+	assert.Equal(t, &MalformedMessage{"Invalid format for field `resources` at position 1"}, err)
+
+	invalid := *message
+	invalid.resources = []url.URL{relative, *valid}
+	assert.Equal(t, &MalformedMessage{"Invalid format for field `resources` at position 0"}, invalid.Validate())
+}
+
+func TestMessageBuilder(t *testing.T) {
+	issued, _ := iso8601.ParseString(issuedAt)
+	expiration, _ := iso8601.ParseString(expirationTime)
+	notBeforeTime, _ := iso8601.ParseString(notBefore)
+
+	built, err := NewMessageBuilder().
+		WithDomain(domain).
+		WithAddress(addressStr).
+		WithURI(uri).
+		WithNonce(nonce).
+		WithStatement(statement).
+		WithChainID(chainId).
+		WithIssuedAt(issued).
+		WithExpirationTime(expiration).
+		WithNotBefore(notBeforeTime).
+		WithRequestID(requestId).
+		WithResources(resources...).
+		Build()
+	assert.Nil(t, err)
+	compareMessage(t, message, built)
+
+	_, err = NewMessageBuilder().WithAddress(addressStr).WithURI(uri).WithNonce(nonce).Build()
+	assert.Equal(t, &MalformedMessage{"`domain` must not be empty"}, err)
+
+	_, err = NewMessageBuilder().WithDomain(domain).WithAddress(addressStr).WithURI(uri).Build()
+	assert.Equal(t, &MalformedMessage{"`nonce` must not be empty"}, err)
+
+	// Messages that couldn't be parsed back are rejected
+	_, err = NewMessageBuilder().WithDomain(domain).WithAddress(addressStr).WithURI(uri).WithNonce("ab").Build()
+	assert.Equal(t, &MalformedMessage{"`nonce` must be at least 8 alphanumeric characters"}, err)
+}
+
+func TestCreateWrongTypes(t *testing.T) {
+	cases := map[string]interface{}{
+		"statement":      42,
+		"chainId":        []int{1},
+		"issuedAt":       time.Now().Unix(),
+		"expirationTime": 3600,
+		"notBefore":      true,
+		"resources":      resourcesStr,
+	}
+
+	for key, value := range cases {
+		assert.NotPanics(t, func() {
+			_, err := InitMessage(domain, addressStr, uri, nonce, map[string]interface{}{key: value})
+			assert.Error(t, err, key)
+		}, key)
+	}
+}
+
+func TestParseRequestID(t *testing.T) {
+	parse, err := ParseMessage(message.String())
+	assert.Nil(t, err)
+
+	if assert.NotNil(t, parse.GetRequestID()) {
+		assert.Equal(t, requestId, *parse.GetRequestID())
+	}
+	assert.Contains(t, parse.String(), fmt.Sprintf("\nRequest ID: %s", requestId))
+}
+
+func TestParseIssuedAt(t *testing.T) {
+	const original = "2021-12-07T18:28:18.807Z"
+	input := strings.Replace(walletMessage, "Issued At: 2022-12-01T12:00:00Z", "Issued At: "+original, 1)
+
+	parse, err := ParseMessage(input)
+	assert.Nil(t, err)
+	assert.Equal(t, original, parse.GetIssuedAt())
+	assert.Equal(t, input, parse.String())
+}
+
+func TestParseOptionalTimestamps(t *testing.T) {
+	const expiration = "2022-12-08T12:00:00.000+02:00"
+	const notBeforeValue = "2022-11-30T12:00:00Z"
+
+	input := fmt.Sprintf("%s\nExpiration Time: %s\nNot Before: %s", walletMessage, expiration, notBeforeValue)
+	parse, err := ParseMessage(input)
+	assert.Nil(t, err)
+	if assert.NotNil(t, parse.GetExpirationTime()) && assert.NotNil(t, parse.GetNotBefore()) {
+		assert.Equal(t, expiration, *parse.GetExpirationTime())
+		assert.Equal(t, notBeforeValue, *parse.GetNotBefore())
+	}
+	assert.Equal(t, input, parse.String())
+
+	parse, err = ParseMessage(walletMessage)
+	assert.Nil(t, err)
+	assert.Nil(t, parse.GetExpirationTime())
+	assert.Nil(t, parse.GetNotBefore())
+}
+
+func TestParseMinimal(t *testing.T) {
+	minimal, err := InitMessage(domain, addressStr, uri, nonce, map[string]interface{}{})
+	assert.Nil(t, err)
+
+	parse, err := ParseMessage(minimal.String())
+	assert.Nil(t, err)
+
+	assert.Nil(t, parse.GetScheme())
+	assert.Nil(t, parse.GetStatement())
+	assert.Nil(t, parse.GetExpirationTime())
+	assert.Nil(t, parse.GetNotBefore())
+	assert.Nil(t, parse.GetRequestID())
+	assert.Nil(t, parse.GetResources())
+	assert.Equal(t, minimal.String(), parse.String())
+}
+
+func TestPrepareNilOptionals(t *testing.T) {
+	blank := " "
+	assert.True(t, isBlank(nil))
+	assert.True(t, isBlank(&blank))
+
+	bare := &Message{
+		domain:   domain,
+		address:  address,
+		version:  version,
+		nonce:    nonce,
+		chainID:  chainId,
+		issuedAt: issuedAt,
+	}
+
+	var prepare string
+	assert.NotPanics(t, func() { prepare = bare.String() })
+	assert.Contains(t, prepare, fmt.Sprintf("%s\n\n\nURI: ", addressStr))
+}
+
+func TestParseLimits(t *testing.T) {
+	var builder strings.Builder
+	builder.WriteString(walletMessage)
+	builder.WriteString("\nResources:")
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&builder, "\n- https://example.com/resources/%d", i)
+	}
+	huge := builder.String()
+
+	_, err := ParseMessage(huge)
+	assert.Equal(t, &InvalidMessage{"Message exceeds the maximum length of 65536 bytes"}, err)
+
+	_, err = ParseMessageWithLimits(huge, ParseLimits{MaxResources: 256})
+	assert.Equal(t, &InvalidMessage{"`resources` exceeds the maximum of 256 entries"}, err)
+
+	parse, err := ParseMessageWithLimits(huge, ParseLimits{})
+	assert.Nil(t, err)
+	assert.Len(t, parse.GetResources(), 10000)
+
+	long := strings.Replace(walletMessage, "Sign in with Ethereum to the app.", strings.Repeat("a", 5000), 1)
+	_, err = ParseMessage(long)
+	assert.Equal(t, &InvalidMessage{"`statement` exceeds the maximum length of 4096 bytes"}, err)
+}
+
+func TestEqual(t *testing.T) {
+	parse, err := ParseMessage(message.String())
+	assert.Nil(t, err)
+	assert.True(t, message.Equal(parse))
+	assert.True(t, parse.Equal(message))
+
+	otherNonce, err := InitMessage(domain, addressStr, uri, MustGenerateNonce(), options)
+	assert.Nil(t, err)
+	assert.False(t, message.Equal(otherNonce))
+
+	reordered := *message
+	reordered.resources = []url.URL{resources[1], resources[0]}
+	assert.False(t, message.Equal(&reordered))
+
+	assert.False(t, message.Equal(nil))
+}
+
+func TestClone(t *testing.T) {
+	original := message.Clone()
+	clone := message.Clone()
+	assert.True(t, message.Equal(clone))
+
+	clone.resources[0].Path = "/tampered"
+	*clone.statement = "Tampered statement"
+	assert.False(t, message.Equal(clone))
+	assert.True(t, message.Equal(original))
+	assert.Equal(t, "/resources/1", message.resources[0].Path)
+	assert.Equal(t, statement, *message.statement)
+}
+
+func TestParseTimestampAccessors(t *testing.T) {
+	expected, _ := iso8601.ParseString(expirationTime)
+
+	parsed, ok, err := message.ParseExpirationTime()
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.True(t, expected.Equal(parsed))
+
+	_, ok, err = message.ParseIssuedAt()
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	minimal, err := InitMessage(domain, addressStr, uri, nonce, map[string]interface{}{})
+	assert.Nil(t, err)
+	_, ok, err = minimal.ParseNotBefore()
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	malformed := message.Clone()
+	*malformed.notBefore = "yesterday"
+	_, ok, err = malformed.ParseNotBefore()
+	assert.Error(t, err)
+	assert.True(t, ok)
+}
+
+func TestParseTimestampValidity(t *testing.T) {
+	valid := strings.Replace(walletMessage, "2022-12-01T12:00:00Z", "2016-12-31T23:59:59Z", 1)
+	_, err := ParseMessage(valid)
+	assert.Nil(t, err)
+
+	// Leap seconds match the grammar but can't be represented by time.Time, so they are rejected
+	leapSecond := strings.Replace(walletMessage, "2022-12-01T12:00:00Z", "2016-12-31T23:59:60Z", 1)
+	_, err = ParseMessage(leapSecond)
+	assert.Equal(t, &MalformedMessage{"Invalid format for field `issuedAt`"}, err)
+
+	_, err = InitMessage(domain, addressStr, uri, nonce, map[string]interface{}{
+		"expirationTime": "2022-12-01T12:00:00",
+	})
+	assert.Equal(t, &MalformedMessage{"Invalid format for field `expirationTime`"}, err)
+}
+
+func TestVerifyAllowedDomains(t *testing.T) {
+	message, err := ParseMessage(walletMessage)
+	assert.Nil(t, err)
+
+	_, err = message.VerifyWithOptions(walletSignature, VerifyOptions{
+		AllowedDomains: []string{"example.com", "localhost:3000"},
+	})
+	assert.Nil(t, err)
+
+	_, err = message.VerifyWithOptions(walletSignature, VerifyOptions{
+		AllowedDomains: []string{"example.com", "login.xyz"},
+	})
+	assert.Equal(t, &DomainMismatch{"Message domain doesn't match"}, err)
+
+	_, err = message.VerifyWithOptions(walletSignature, VerifyOptions{
+		AllowedDomains: []string{"LocalHost:3000"},
+	})
+	assert.Nil(t, err)
+}
+
+func TestParseMessageFromJSON(t *testing.T) {
+	full := fmt.Sprintf(`{
+		"domain": %q,
+		"address": %q,
+		"uri": %q,
+		"version": "1",
+		"statement": %q,
+		"nonce": %q,
+		"chainId": 1,
+		"issuedAt": %q,
+		"expirationTime": %q,
+		"notBefore": %q,
+		"requestId": %q,
+		"resources": [%q, %q]
+	}`, domain, addressStr, uri, statement, nonce, issuedAt, expirationTime, notBefore, requestId, resourcesStr[0], resourcesStr[1])
+
+	parsed, err := ParseMessageFromJSON([]byte(full))
+	assert.Nil(t, err)
+	compareMessage(t, message, parsed)
+
+	minimal := fmt.Sprintf(`{"domain": %q, "address": %q, "uri": %q, "nonce": %q}`, domain, addressStr, uri, nonce)
+	parsed, err = ParseMessageFromJSON([]byte(minimal))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, parsed.GetChainID())
+	assert.NotEmpty(t, parsed.GetIssuedAt())
+
+	missingNonce := fmt.Sprintf(`{"domain": %q, "address": %q, "uri": %q}`, domain, addressStr, uri)
+	parsed, err = ParseMessageFromJSON([]byte(missingNonce))
+	assert.Nil(t, parsed)
+	assert.Equal(t, &MalformedMessage{"`nonce` must not be empty"}, err)
+
+	badNonce := fmt.Sprintf(`{"domain": %q, "address": %q, "uri": %q, "nonce": "x!"}`, domain, addressStr, uri)
+	parsed, err = ParseMessageFromJSON([]byte(badNonce))
+	assert.Nil(t, parsed)
+	assert.Equal(t, &MalformedMessage{"`nonce` must be at least 8 alphanumeric characters"}, err)
+
+	zeroAddress := fmt.Sprintf(`{"domain": %q, "address": "0x0000000000000000000000000000000000000000", "uri": %q, "nonce": %q}`, domain, uri, nonce)
+	parsed, err = ParseMessageFromJSON([]byte(zeroAddress))
+	assert.Nil(t, parsed)
+	assert.Equal(t, &MalformedMessage{"`address` must not be empty"}, err)
+}
+
+func TestPrepareParseURIComponents(t *testing.T) {
+	const full = "https://example.com/path?x=1#frag"
+	message, err := InitMessage(domain, addressStr, full, nonce, map[string]interface{}{
+		"resources": []url.URL{*mustParseURL(t, "ipfs://bafybeiemxf5abjwjbikoz4mc3a3dla6ual3jsgpdr4cjr3oz3evfyavhwq/?a=b#c")},
+	})
+	assert.Nil(t, err)
+	assert.Contains(t, message.String(), "URI: "+full+"\n")
+
+	parse, err := ParseMessage(message.String())
+	assert.Nil(t, err)
+	uri := parse.GetURI()
+	assert.Equal(t, full, uri.String())
+	assert.Equal(t, "x=1", uri.RawQuery)
+	assert.Equal(t, "frag", uri.Fragment)
+	compareMessage(t, message, parse)
+}
+
+func mustParseURL(t *testing.T, raw string) *url.URL {
+	parsed, err := url.Parse(raw)
+	assert.Nil(t, err)
+	return parsed
+}
+
+func TestVerifySignerAddress(t *testing.T) {
+	message, err := ParseMessage(walletMessage)
+	assert.Nil(t, err)
+
+	ok, err := message.VerifySignerAddress(walletSignature, common.HexToAddress(walletAddress))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	ok, err = message.VerifySignerAddress(walletSignature, address)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	ok, err = message.VerifySignerAddress("", address)
+	assert.Equal(t, &InvalidSignature{"Signature cannot be empty"}, err)
+	assert.False(t, ok)
+}
+
+func TestCreateMultilineStatement(t *testing.T) {
+	single, err := InitMessage(domain, addressStr, uri, nonce, map[string]interface{}{
+		"statement": "A single line statement",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "A single line statement", *single.GetStatement())
+
+	_, err = InitMessage(domain, addressStr, uri, nonce, map[string]interface{}{
+		"statement": "A statement\nwrapped over two lines",
+	})
+	assert.Equal(t, &MalformedMessage{"`statement` must not contain line breaks"}, err)
+
+	wrapped := strings.Replace(walletMessage, "Sign in with Ethereum to the app.", "Sign in with Ethereum\nto the app.", 1)
+	_, err = ParseMessage(wrapped)
+	assert.Error(t, err)
+}
+
+func TestVersion(t *testing.T) {
+	assert.Equal(t, []string{"1"}, SupportedVersions)
+
+	parse, err := ParseMessage(walletMessage)
+	assert.Nil(t, err)
+	assert.Equal(t, Version, parse.GetVersion())
+
+	unsupported := strings.Replace(walletMessage, "Version: 1", "Version: 2", 1)
+	_, err = ParseMessage(unsupported)
+	assert.Equal(t, &InvalidVersion{"Unsupported message version `2`"}, err)
+
+	_, err = ParseMessageStrict(unsupported)
+	assert.Equal(t, &InvalidVersion{"Unsupported message version `2`"}, err)
+
+	_, err = InitMessage(domain, addressStr, uri, nonce, map[string]interface{}{"version": "2"})
+	assert.Equal(t, &InvalidVersion{"Unsupported message version `2`"}, err)
+}
+
+func TestErrorTypes(t *testing.T) {
+	message, err := ParseMessage(walletMessage)
+	assert.Nil(t, err)
+
+	var notYetValid *NotYetValidMessage
+	early := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	future := message.Clone()
+	notBefore := "2022-12-02T00:00:00Z"
+	future.notBefore = &notBefore
+	_, err = future.VerifyWithOptions(walletSignature, VerifyOptions{Timestamp: &early})
+	assert.True(t, errors.As(err, &notYetValid))
+
+	var expired *ExpiredMessage
+	expiration := "2022-12-01T13:00:00Z"
+	past := message.Clone()
+	past.expirationTime = &expiration
+	_, err = past.VerifyWithOptions(walletSignature, VerifyOptions{})
+	assert.True(t, errors.As(err, &expired))
+
+	var domainMismatch *DomainMismatch
+	otherDomain := "example.org"
+	_, err = message.VerifyWithOptions(walletSignature, VerifyOptions{Domain: &otherDomain})
+	assert.True(t, errors.As(err, &domainMismatch))
+
+	var nonceMismatch *NonceMismatch
+	otherNonce := MustGenerateNonce()
+	_, err = message.VerifyWithOptions(walletSignature, VerifyOptions{Nonce: &otherNonce})
+	assert.True(t, errors.As(err, &nonceMismatch))
+
+	var malformed *MalformedMessage
+	_, err = InitMessage(domain, addressStr, "/login", nonce, map[string]interface{}{})
+	assert.True(t, errors.As(err, &malformed))
+
+	malformed = nil
+	_, err = ParseMessage("garbage")
+	assert.True(t, errors.As(err, &malformed))
+	assert.Equal(t, "Invalid Message: Message could not be parsed at `domain`", malformed.Error())
+	assert.True(t, errors.Is(err, ErrInvalidMessage))
+
+	// Malformed messages still match the InvalidMessage type they were reported as
+	var invalid *InvalidMessage
+	_, err = InitMessage(domain, addressStr, "/login", nonce, map[string]interface{}{})
+	assert.True(t, errors.As(err, &invalid))
+	assert.Equal(t, &InvalidMessage{"Invalid format for field `uri`"}, invalid)
+
+	// Problems with the message itself are malformed wherever they're found
+	malformedInputs := map[string]func() error{
+		"checksum": func() error {
+			_, err := InitMessage(domain, "0x71c7656EC7ab88b098defB751B7401B5f6d8976F", uri, nonce, map[string]interface{}{})
+			return err
+		},
+		"statement type": func() error {
+			_, err := InitMessage(domain, addressStr, uri, nonce, map[string]interface{}{"statement": 1})
+			return err
+		},
+		"chainId type": func() error {
+			_, err := InitMessage(domain, addressStr, uri, nonce, map[string]interface{}{"chainId": 1.5})
+			return err
+		},
+		"resources type": func() error {
+			_, err := InitMessage(domain, addressStr, uri, nonce, map[string]interface{}{"resources": []string{uri}})
+			return err
+		},
+		"timestamp type": func() error {
+			_, err := InitMessage(domain, addressStr, uri, nonce, map[string]interface{}{"expirationTime": 1})
+			return err
+		},
+		"chainId": func() error {
+			_, err := (&Message{}).GetCAIP2ChainID()
+			return err
+		},
+	}
+	for name, input := range malformedInputs {
+		malformed = nil
+		assert.True(t, errors.As(input(), &malformed), name)
+	}
+
+	// Policy failures on well-formed messages aren't malformed
+	_, err = message.VerifyWithOptions(walletSignature, VerifyOptions{AllowedChainIDs: []int{10}})
+	assert.False(t, errors.As(err, &malformed))
+	assert.True(t, errors.As(err, &invalid))
+
+	lowercase, err := InitMessage(domain, strings.ToLower(addressStr), uri, nonce, map[string]interface{}{})
+	assert.Nil(t, err)
+	_, err = lowercase.VerifyWithOptions(walletSignature, VerifyOptions{RequireChecksumAddress: true})
+	assert.False(t, errors.As(err, &malformed))
+	assert.Equal(t, &InvalidMessage{"Address was not provided in EIP-55 format"}, err)
+
+	var invalidSignature *InvalidSignature
+	_, err = message.VerifyWithOptions(walletSignature[:10], VerifyOptions{})
+	assert.True(t, errors.As(err, &invalidSignature))
+}
+
+func TestErrorSentinels(t *testing.T) {
+	cases := map[error]error{
+		&ExpiredMessage{"Message expired"}:                  ErrExpiredMessage,
+		&MalformedMessage{"`uri` must not be empty"}:        ErrInvalidMessage,
+		&ParseError{"nonce"}:                                ErrInvalidMessage,
+		&MalformedMessage{"Invalid format for field `uri`"}: ErrInvalidMessage,
+		&InvalidSignature{"Signature cannot be empty"}:      ErrInvalidSignature,
+		&InvalidVersion{"Unsupported version"}:              ErrInvalidVersion,
+		&NotYetValidMessage{"Message not yet valid"}:        ErrNotYetValidMessage,
+		&DomainMismatch{"Message domain doesn't match"}:     ErrDomainMismatch,
+		&NonceMismatch{"Message nonce doesn't match"}:       ErrNonceMismatch,
+	}
+
+	for err, sentinel := range cases {
+		assert.True(t, errors.Is(err, sentinel), err.Error())
+		assert.True(t, errors.Is(fmt.Errorf("wrapped: %w", err), sentinel), err.Error())
+		assert.False(t, errors.Is(err, ErrExpiredMessage) && sentinel != ErrExpiredMessage, err.Error())
+	}
+
+	message, err := ParseMessage(walletMessage)
+	assert.Nil(t, err)
+	_, err = message.Verify("", nil, nil, nil)
+	assert.True(t, errors.Is(err, ErrInvalidSignature))
+}
+
+func TestVerifyPublicKey(t *testing.T) {
+	privateKey, address := createWallet(t)
+	otherKey, _ := createWallet(t)
+
+	message, err := InitMessage(domain, address, uri, nonce, options)
+	assert.Nil(t, err)
+
+	assert.Nil(t, message.VerifyPublicKey(&privateKey.PublicKey))
+	assert.Equal(t, &InvalidSignature{"Signer address must match message address"}, message.VerifyPublicKey(&otherKey.PublicKey))
+	assert.Equal(t, &InvalidSignature{"Public key cannot be empty"}, message.VerifyPublicKey(nil))
+
+	expired := message.Clone()
+	expiration := "2022-12-01T12:00:00Z"
+	expired.expirationTime = &expiration
+	assert.Equal(t, &ExpiredMessage{"Message expired"}, expired.VerifyPublicKey(&privateKey.PublicKey))
+}
+
+func TestVerifyBatch(t *testing.T) {
+	items := make([]VerifyItem, 20)
+	for i := range items {
+		privateKey, address := createWallet(t)
+		message, err := InitMessage(domain, address, uri, MustGenerateNonce(), map[string]interface{}{})
+		assert.Nil(t, err)
+
+		signature, err := Sign(message, privateKey)
+		assert.Nil(t, err)
+
+		if i%3 == 0 {
+			signature = walletSignature
+		}
+		items[i] = VerifyItem{Message: message, Signature: signature}
+	}
+	items = append(items, VerifyItem{Signature: walletSignature})
+
+	results := VerifyBatch(items, VerifyOptions{})
+	assert.Len(t, results, len(items))
+
+	for i, result := range results[:len(results)-1] {
+		if i%3 == 0 {
+			assert.Equal(t, &InvalidSignature{"Signer address must match message address"}, result.Err, i)
+			assert.Nil(t, result.PublicKey, i)
+		} else {
+			assert.Nil(t, result.Err, i)
+			assert.Equal(t, items[i].Message.GetAddress(), crypto.PubkeyToAddress(*result.PublicKey), i)
+		}
+	}
+	assert.Equal(t, &MalformedMessage{"Message cannot be empty"}, results[len(results)-1].Err)
+
+	assert.Empty(t, VerifyBatch(nil, VerifyOptions{}))
+}
+
+func BenchmarkEIP191Hash(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		message.eip191Hash()
+	}
+}
+
+func BenchmarkVerifyEIP191(b *testing.B) {
+	message, _ := ParseMessage(walletMessage)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := message.VerifyEIP191(walletSignature); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkValidateMessage(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		message, err := ParseMessage(walletMessage)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := message.VerifyEIP191(walletSignature); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestParseAndVerify(t *testing.T) {
+	expectedNonce := "k7bNPyc9Y2H8rZbT"
+	opts := VerifyOptions{Nonce: &expectedNonce}
+
+	parsed, err := ParseAndVerify(walletMessage, walletSignature, opts)
+	if assert.Nil(t, err) {
+		assert.Equal(t, walletMessage, parsed.String())
+	}
+
+	missingURI := strings.Replace(walletMessage, "URI: http://localhost:3000/login\n", "", 1)
+	_, err = ParseAndVerify(missingURI, walletSignature, opts)
+	assert.Equal(t, &ParseError{"uri"}, err)
+
+	otherNonce := strings.Replace(walletMessage, "Nonce: k7bNPyc9Y2H8rZbT", "Nonce: k7bNPyc9", 1)
+	_, err = ParseAndVerify(otherNonce, walletSignature, opts)
+	assert.Equal(t, &NonceMismatch{"Message nonce doesn't match"}, err)
+
+	expired := walletMessage + "\nExpiration Time: 2022-12-01T13:00:00Z"
+	_, err = ParseAndVerify(expired, walletSignature, opts)
+	assert.Equal(t, &ExpiredMessage{"Message expired"}, err)
+
+	tampered := strings.Replace(walletMessage, "Chain ID: 1", "Chain ID: 5", 1)
+	_, err = ParseAndVerify(tampered, walletSignature, opts)
+	assert.Equal(t, &InvalidSignature{"Signer address must match message address"}, err)
+}
+
+type mockENSResolver map[common.Address]string
+
+var errENSNotFound = errors.New("ens name not found")
+
+func (r mockENSResolver) LookupAddress(ctx context.Context, address common.Address) (string, error) {
+	if name, ok := r[address]; ok {
+		return name, nil
+	}
+	return "", errENSNotFound
+}
+
+func TestResolveENS(t *testing.T) {
+	resolver := mockENSResolver{address: "example.eth"}
+
+	name, err := message.ResolveENS(context.Background(), resolver)
+	assert.Nil(t, err)
+	assert.Equal(t, "example.eth", name)
+
+	other, err := InitMessage(domain, walletAddress, uri, nonce, map[string]interface{}{})
+	assert.Nil(t, err)
+	name, err = other.ResolveENS(context.Background(), resolver)
+	assert.Equal(t, errENSNotFound, err)
+	assert.Empty(t, name)
+}
+
+func TestParseCollapsedBlankLine(t *testing.T) {
+	canonical, err := InitMessage(domain, addressStr, uri, nonce, map[string]interface{}{"issuedAt": issuedAt})
+	assert.Nil(t, err)
+	assert.Contains(t, canonical.String(), addressStr+"\n\n\nURI: ")
+
+	collapsed := strings.Replace(canonical.String(), addressStr+"\n\n\n", addressStr+"\n\n", 1)
+
+	for _, input := range []string{canonical.String(), collapsed} {
+		parse, err := ParseMessage(input)
+		if assert.Nil(t, err) {
+			assert.True(t, canonical.Equal(parse))
+			assert.Equal(t, canonical.String(), parse.String())
+		}
+	}
+
+	_, err = ParseMessageStrict(collapsed)
+	assert.Error(t, err)
+
+	// Statements still require their trailing blank line
+	withStatement := strings.Replace(walletMessage, "app.\n\n", "app.\n", 1)
+	_, err = ParseMessage(withStatement)
+	assert.Error(t, err)
+}
+
+func TestDefaultChainID(t *testing.T) {
+	defer func(previous int) { DefaultChainID = previous }(DefaultChainID)
+
+	message, err := InitMessage(domain, addressStr, uri, nonce, map[string]interface{}{})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, message.GetChainID())
+
+	DefaultChainID = 10
+
+	message, err = InitMessage(domain, addressStr, uri, nonce, map[string]interface{}{})
+	assert.Nil(t, err)
+	assert.Equal(t, 10, message.GetChainID())
+
+	message, err = InitMessage(domain, addressStr, uri, nonce, map[string]interface{}{"chainId": 8453})
+	assert.Nil(t, err)
+	assert.Equal(t, 8453, message.GetChainID())
+}
+
+func TestVerifyRequireExpiration(t *testing.T) {
+	now := time.Now().UTC()
+	opts := VerifyOptions{RequireExpiration: true, MaxValidity: 24 * time.Hour}
+
+	testVerifyOptions(t, []verifyOptionsCase{
+		{"no expiration", nil, opts, &InvalidMessage{"Message must have an expiration time"}},
+		{"too far", map[string]interface{}{"expirationTime": now.Add(30 * 24 * time.Hour)}, opts, &InvalidMessage{"Message expiration time is too far in the future"}},
+		{"within validity", map[string]interface{}{"expirationTime": now.Add(time.Hour)}, opts, nil},
+	})
+}
+
+func TestSignHash(t *testing.T) {
+	expected := accounts.TextHash([]byte(message.String()))
+	assert.Equal(t, expected, message.SignHash().Bytes())
+
+	parse, err := ParseMessage(walletMessage)
+	assert.Nil(t, err)
+	assert.Equal(t, accounts.TextHash([]byte(walletMessage)), parse.SignHash().Bytes())
+}
+
+func TestVerifyRequireChecksumAddress(t *testing.T) {
+	opts := VerifyOptions{RequireChecksumAddress: true}
+	lowercase := map[string]interface{}{"address": strings.ToLower(signerAddress)}
+
+	testVerifyOptions(t, []verifyOptionsCase{
+		{"checksummed", nil, opts, nil},
+		{"lowercase", lowercase, VerifyOptions{}, nil},
+		{"lowercase required", lowercase, opts, &InvalidMessage{"Address was not provided in EIP-55 format"}},
+	})
+
+	// Corrupted checksums never make it into a Message
+	_, err := InitMessage(domain, "0x71c7656EC7ab88b098defB751B7401B5f6d8976F", uri, nonce, map[string]interface{}{})
+	assert.Equal(t, &MalformedMessage{"Address has an invalid EIP-55 checksum"}, err)
+}
+
+func TestPrepareParseResourceEncoding(t *testing.T) {
+	raw := []string{
+		"https://example.com/my%20claim.json",
+		"https://example.com:8443/claims/1",
+		"https://example.com/claims/a:b?q=x%2Fy#section",
+		"urn:uuid:6e8bc430-9c3a-11d9-9669-0800200c9a66",
+	}
+	parsed := make([]url.URL, len(raw))
+	for i, resource := range raw {
+		parsed[i] = *mustParseURL(t, resource)
+	}
+
+	message, err := InitMessage(domain, addressStr, uri, nonce, map[string]interface{}{"resources": parsed})
+	assert.Nil(t, err)
+	assert.True(t, strings.HasSuffix(message.String(), "\nResources:\n- "+strings.Join(raw, "\n- ")))
+
+	parse, err := ParseMessage(message.String())
+	if assert.Nil(t, err) {
+		for i, resource := range parse.GetResources() {
+			assert.Equal(t, raw[i], resource.String())
+		}
+		assert.Equal(t, message.String(), parse.String())
+	}
+}
+
+func TestParseNonCanonicalURIs(t *testing.T) {
+	uppercase := walletMessage + "\nResources:\n- https://example.com/claim\n- HTTPS://example.com/claim"
+	_, err := ParseMessage(uppercase)
+	assert.Equal(t, &MalformedMessage{"Invalid format for field `resources` at position 1"}, err)
+
+	unescaped := strings.Replace(walletMessage, "http://localhost:3000/login", "http://localhost:3000/lögin", 1)
+	_, err = ParseMessage(unescaped)
+	assert.Equal(t, &MalformedMessage{"Invalid format for field `uri`"}, err)
+}
+
+func TestVerifyMaxIssuedAtAge(t *testing.T) {
+	now := time.Now().UTC()
+	opts := VerifyOptions{MaxIssuedAtAge: 5 * time.Minute}
+
+	testVerifyOptions(t, []verifyOptionsCase{
+		{"stale", map[string]interface{}{"issuedAt": now.Add(-time.Hour)}, opts, &InvalidMessage{"Message was issued too long ago"}},
+		{"future", map[string]interface{}{"issuedAt": now.Add(time.Hour)}, opts, &InvalidMessage{"Message issuance time is in the future"}},
+		{"fresh", map[string]interface{}{"issuedAt": now.Add(-time.Minute)}, opts, nil},
+	})
+}
+
+func TestVerifyClockSkew(t *testing.T) {
+	now := time.Now().UTC()
+	exact := VerifyOptions{Timestamp: &now}
+	skewed := VerifyOptions{Timestamp: &now, ClockSkew: 30 * time.Second}
+	expired := map[string]interface{}{"expirationTime": now.Add(-10 * time.Second)}
+	early := map[string]interface{}{"notBefore": now.Add(10 * time.Second)}
+
+	testVerifyOptions(t, []verifyOptionsCase{
+		{"expired", expired, exact, &ExpiredMessage{"Message expired"}},
+		{"expired within skew", expired, skewed, nil},
+		{"not yet valid", early, exact, &NotYetValidMessage{"Message not yet valid"}},
+		{"not yet valid within skew", early, skewed, nil},
+		{"issued within skew", map[string]interface{}{"issuedAt": now.Add(10 * time.Second)}, VerifyOptions{Timestamp: &now, MaxIssuedAtAge: time.Minute, ClockSkew: 30 * time.Second}, nil},
+	})
+}
+
+func TestGetResourceURLs(t *testing.T) {
+	urls, err := message.GetResourceURLs()
+	assert.Nil(t, err)
+	if assert.Len(t, urls, 2) {
+		assert.Equal(t, "example.com", urls[0].Host)
+		assert.Equal(t, "/resources/2", urls[1].Path)
+	}
+
+	urls[0].Host = "tampered.example"
+	assert.Equal(t, "example.com", message.GetResources()[0].Host)
+
+	malformed := message.Clone()
+	malformed.resources = append(malformed.resources, url.URL{Path: "relative"})
+	urls, err = malformed.GetResourceURLs()
+	assert.Nil(t, urls)
+	assert.Equal(t, &MalformedMessage{"Invalid format for field `resources` at position 2"}, err)
+}
+
+func TestDTORoundTrip(t *testing.T) {
+	dto := MessageDTO{
+		Scheme:         "https",
+		Domain:         domain,
+		Address:        addressStr,
+		URI:            uri,
+		Version:        version,
+		Statement:      statement,
+		Nonce:          nonce,
+		ChainID:        137,
+		IssuedAt:       issuedAt,
+		ExpirationTime: expirationTime,
+		NotBefore:      notBefore,
+		RequestID:      requestId,
+		Resources:      resourcesStr,
+	}
+
+	message, err := FromDTO(dto)
+	assert.Nil(t, err)
+	assert.Equal(t, dto, message.ToDTO())
+
+	minimal, err := FromDTO(MessageDTO{Domain: domain, Address: addressStr, URI: uri, Nonce: nonce})
+	assert.Nil(t, err)
+	assert.Nil(t, minimal.GetStatement())
+	assert.Equal(t, "", minimal.ToDTO().Statement)
+
+	_, err = FromDTO(MessageDTO{Domain: domain, URI: uri, Nonce: nonce})
+	assert.Equal(t, &MalformedMessage{"`address` must not be empty"}, err)
+
+	invalidNonce, err := FromDTO(MessageDTO{Domain: domain, Address: addressStr, URI: uri, Nonce: "x!"})
+	assert.Nil(t, invalidNonce)
+	assert.Equal(t, &MalformedMessage{"`nonce` must be at least 8 alphanumeric characters"}, err)
+
+	var decoded Message
+	err = json.Unmarshal([]byte(fmt.Sprintf(`{"domain": %q, "address": %q, "uri": %q, "nonce": "x!"}`, domain, addressStr, uri)), &decoded)
+	assert.Equal(t, &MalformedMessage{"`nonce` must be at least 8 alphanumeric characters"}, err)
+}
+
+func TestVerifyEIP712Fallback(t *testing.T) {
+	privateKey, address := createWallet(t)
+
+	message, err := InitMessage(domain, address, uri, nonce, map[string]interface{}{
+		"statement": statement,
+		"resources": resources,
+	})
+	assert.Nil(t, err)
+
+	hash, _, err := apitypes.TypedDataAndHash(message.TypedData())
+	assert.Nil(t, err)
+
+	signature, err := crypto.Sign(hash, privateKey)
+	assert.Nil(t, err)
+	signature[64] += 27
+	sigHex := hexutil.Encode(signature)
+
+	_, err = message.VerifyWithOptions(sigHex, VerifyOptions{})
+	assert.NotNil(t, err)
+
+	pkey, err := message.VerifyWithOptions(sigHex, VerifyOptions{AllowEIP712: true})
+	assert.Nil(t, err)
+	assert.Equal(t, address, crypto.PubkeyToAddress(*pkey).Hex())
+
+	personalSig, err := Sign(message, privateKey)
+	assert.Nil(t, err)
+	_, err = message.VerifyWithOptions(personalSig, VerifyOptions{AllowEIP712: true})
+	assert.Nil(t, err)
+
+	other, _ := createWallet(t)
+	otherSig, err := Sign(message, other)
+	assert.Nil(t, err)
+	_, err = message.VerifyWithOptions(otherSig, VerifyOptions{AllowEIP712: true})
+	assert.Equal(t, &InvalidSignature{"Signer address must match message address"}, err)
+}
+
+func TestGenerateReadableNonce(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		nonce, err := GenerateReadableNonce(32)
+		assert.Nil(t, err)
+		assert.Len(t, nonce, 32)
+		assert.True(t, _SIWE_NONCE_VALUE.MatchString(nonce))
+		assert.False(t, strings.ContainsAny(nonce, "0O1lI"), nonce)
+	}
+
+	_, err := GenerateReadableNonce(7)
+	assert.Equal(t, &InvalidMessage{"`nonce` must be at least 8 characters long"}, err)
+}
+
+func TestVerifyForbidStatement(t *testing.T) {
+	opts := VerifyOptions{ForbidStatement: true}
+
+	testVerifyOptions(t, []verifyOptionsCase{
+		{"statement", map[string]interface{}{"statement": statement}, opts, &InvalidMessage{"Message must not have a statement"}},
+		{"no statement", nil, opts, nil},
+	})
+}
+
+func TestGetCAIP2ChainID(t *testing.T) {
+	message, err := InitMessage(domain, addressStr, uri, nonce, map[string]interface{}{
+		"chainId": "1",
+	})
+	assert.Nil(t, err)
+
+	caip2, err := message.GetCAIP2ChainID()
+	assert.Nil(t, err)
+	assert.Equal(t, "eip155:1", caip2)
+
+	_, err = (&Message{}).GetCAIP2ChainID()
+	assert.Equal(t, &MalformedMessage{"`chainId` must be a positive integer"}, err)
+}
+
+func TestVerifyAndConsume(t *testing.T) {
+	privateKey, address := createWallet(t)
+	store := NewMemoryNonceStore(time.Minute)
+
+	issued, err := store.Issue()
+	assert.Nil(t, err)
+
+	message, err := InitMessage(domain, address, uri, issued, map[string]interface{}{})
+	assert.Nil(t, err)
+	signature, err := Sign(message, privateKey)
+	assert.Nil(t, err)
+
+	parsed, err := VerifyAndConsume(message.String(), signature, store, VerifyOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, issued, parsed.GetNonce())
+
+	_, err = VerifyAndConsume(message.String(), signature, store, VerifyOptions{})
+	assert.Equal(t, &NonceMismatch{"Message nonce is unknown or was already used"}, err)
+
+	unknown, err := InitMessage(domain, address, uri, MustGenerateNonce(), map[string]interface{}{})
+	assert.Nil(t, err)
+	signature, err = Sign(unknown, privateKey)
+	assert.Nil(t, err)
+
+	_, err = VerifyAndConsume(unknown.String(), signature, store, VerifyOptions{})
+	assert.True(t, errors.Is(err, ErrNonceMismatch))
+}
+
+type recordingHook struct {
+	events []VerifyEvent
+}
+
+func (h *recordingHook) OnVerifyEvent(event VerifyEvent) {
+	h.events = append(h.events, event)
+}
+
+func (h *recordingHook) stages() []VerifyStage {
+	stages := make([]VerifyStage, len(h.events))
+	for i, event := range h.events {
+		stages[i] = event.Stage
+	}
+	return stages
+}
+
+func TestVerifyHook(t *testing.T) {
+	privateKey, address := createWallet(t)
+
+	message, err := InitMessage(domain, address, uri, nonce, map[string]interface{}{})
+	assert.Nil(t, err)
+	signature, err := Sign(message, privateKey)
+	assert.Nil(t, err)
+
+	hook := &recordingHook{}
+	_, err = ParseAndVerify(message.String(), signature, VerifyOptions{Hook: hook})
+	assert.Nil(t, err)
+	assert.Equal(t, []VerifyStage{StageParsed, StageHashComputed, StageSignerRecovered, StageDone}, hook.stages())
+	assert.Equal(t, message.SignHash(), hook.events[1].Hash)
+	assert.Equal(t, address, hook.events[2].Signer.Hex())
+	assert.Nil(t, hook.events[3].Err)
+
+	hook = &recordingHook{}
+	wrongNonce := "wrongnonce"
+	_, err = message.VerifyWithOptions(signature, VerifyOptions{Nonce: &wrongNonce, Hook: hook})
+	assert.NotNil(t, err)
+	assert.Equal(t, []VerifyStage{StageDone}, hook.stages())
+	assert.Equal(t, err, hook.events[0].Err)
+
+	// The recovered signer is reported even when it doesn't match
+	otherKey, otherAddress := createWallet(t)
+	otherSignature, err := Sign(message, otherKey)
+	assert.Nil(t, err)
+
+	hook = &recordingHook{}
+	_, err = message.VerifyWithOptions(otherSignature, VerifyOptions{Hook: hook})
+	assert.IsType(t, &InvalidSignature{}, err)
+	assert.Equal(t, []VerifyStage{StageHashComputed, StageSignerRecovered, StageDone}, hook.stages())
+	assert.Equal(t, otherAddress, hook.events[1].Signer.Hex())
+}
+
+func TestPrepareMessageBytes(t *testing.T) {
+	assert.Equal(t, []byte(message.String()), message.PrepareMessageBytes())
+
+	parsed, err := ParseMessage(walletMessage)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte(walletMessage), parsed.PrepareMessageBytes())
+}
+
+func BenchmarkPrepareMessageBytes(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		message.PrepareMessageBytes()
+	}
+}
+
+func TestParseMessageCRLF(t *testing.T) {
+	parsed, err := ParseMessage(strings.ReplaceAll(walletMessage, "\n", "\r\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, walletMessage, parsed.String())
+
+	_, err = parsed.VerifyEIP191(walletSignature)
+	assert.Nil(t, err)
+}
+
+func TestVerifyDomainMatchesURIHost(t *testing.T) {
+	opts := VerifyOptions{RequireDomainMatchesURIHost: true}
+	mismatch := &DomainMismatch{"Message domain doesn't match URI host"}
+	origin := func(domain, uri string) map[string]interface{} {
+		return map[string]interface{}{"domain": domain, "uri": uri}
+	}
+
+	testVerifyOptions(t, []verifyOptionsCase{
+		{"same host", origin("example.com", "https://example.com/login"), opts, nil},
+		{"case-insensitive", origin("Example.com", "https://example.com/login"), opts, nil},
+		{"domain without port", origin("example.com", "https://example.com:8443/login"), opts, nil},
+		{"same port", origin("example.com:8443", "https://example.com:8443/login"), opts, nil},
+		{"missing port", origin("example.com:8443", "https://example.com/login"), opts, mismatch},
+		{"other port", origin("example.com:8443", "https://example.com:443/login"), opts, mismatch},
+		{"other host", origin("example.com", "https://evil.com/login"), opts, mismatch},
+		{"IPv6", origin("[::1]:8080", "http://[::1]:8080/"), opts, nil},
+	})
+}
+
+func TestLint(t *testing.T) {
+	assert.Empty(t, Lint(walletMessage))
+	assert.Empty(t, Lint(message.String()))
+
+	lines := strings.Split(walletMessage, "\n")
+	broken := func(index int, line string) string {
+		copied := append([]string{}, lines...)
+		copied[index] = line
+		return strings.Join(copied, "\n")
+	}
+
+	issues := Lint(strings.Replace(walletMessage, "wants you to sign in", "wants you to login", 1))
+	assert.Equal(t, []LintIssue{{1, "domain", fmt.Sprintf("greeting must end with %q", _GREETING_SUFFIX)}}, issues)
+
+	issues = Lint(broken(1, strings.ToLower(lines[1])))
+	assert.Equal(t, []LintIssue{{2, "address", "is not in EIP-55 checksum format"}}, issues)
+
+	issues = Lint(broken(2, "Sign in please"))
+	assert.Equal(t, []LintIssue{{3, "address", "must be followed by a blank line"}}, issues)
+
+	for i, line := range lines {
+		if strings.HasPrefix(line, "Nonce: ") {
+			issues = Lint(broken(i, "Nonce: abc"))
+			assert.Equal(t, []LintIssue{{i + 1, "nonce", "must be at least 8 alphanumeric characters"}}, issues)
+		}
+	}
+
+	issues = Lint(strings.Join(lines[:len(lines)-1], "\n"))
+	assert.Contains(t, issues, LintIssue{0, "issuedAt", "is missing, expected a line starting with \"Issued At: \""})
+
+	// Statements that look like a field aren't mistaken for one
+	labelled, err := InitMessage(domain, walletAddress, uri, nonce, map[string]interface{}{
+		"statement": "URI: https://evil.com",
+	})
+	assert.Nil(t, err)
+	_, err = ParseMessage(labelled.String())
+	assert.Nil(t, err)
+	assert.Empty(t, Lint(labelled.String()))
+
+	issues = Lint(strings.ReplaceAll(walletMessage, "\n", "\r\n"))
+	assert.Equal(t, []LintIssue{{0, "message", "must use LF line endings"}}, issues)
+}
+
+func TestConcurrentParseAndPrepare(t *testing.T) {
+	shared := []url.URL{*mustParseURL(t, "https://example.com/resource")}
+	prepared := message.String()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				parsed, err := ParseMessage(walletMessage)
+				if !assert.Nil(t, err) {
+					return
+				}
+				assert.Equal(t, walletMessage, parsed.String())
+				assert.Equal(t, prepared, message.String())
+
+				built, err := InitMessage(domain, addressStr, uri, nonce, map[string]interface{}{
+					"resources": shared,
+				})
+				if !assert.Nil(t, err) {
+					return
+				}
+				assert.Equal(t, shared[0].String(), built.GetResources()[0].String())
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestMatchFields(t *testing.T) {
+	fields, ok := MatchFields(message.String())
+	assert.True(t, ok)
+	assert.Equal(t, map[string]string{
+		"domain":         domain,
+		"address":        addressStr,
+		"statement":      statement,
+		"uri":            uri,
+		"version":        version,
+		"chainId":        "1",
+		"nonce":          message.GetNonce(),
+		"issuedAt":       message.GetIssuedAt(),
+		"expirationTime": *message.GetExpirationTime(),
+		"notBefore":      *message.GetNotBefore(),
+		"requestId":      requestId,
+		"resources":      "\n- " + strings.Join(resourcesStr, "\n- "),
+	}, fields)
+
+	fields, ok = MatchFields(walletMessage)
+	assert.True(t, ok)
+	assert.NotContains(t, fields, "expirationTime")
+	assert.NotContains(t, fields, "scheme")
+
+	fields, ok = MatchFields("not a siwe message")
+	assert.False(t, ok)
+	assert.Nil(t, fields)
+}
+
+func TestSignWithKeystore(t *testing.T) {
+	ks := keystore.NewKeyStore(t.TempDir(), keystore.LightScryptN, keystore.LightScryptP)
+
+	privateKey, address := createWallet(t)
+	account, err := ks.ImportECDSA(privateKey, "passphrase")
+	assert.Nil(t, err)
+	assert.Equal(t, address, account.Address.Hex())
+
+	message, err := InitMessage(domain, address, uri, nonce, map[string]interface{}{})
+	assert.Nil(t, err)
+
+	signature, err := SignWithKeystore(message, ks, account, "passphrase")
+	assert.Nil(t, err)
+
+	expected, err := Sign(message, privateKey)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, signature)
+
+	_, err = message.VerifyEIP191(signature)
+	assert.Nil(t, err)
+
+	_, err = SignWithKeystore(message, ks, account, "wrong")
+	assert.Equal(t, keystore.ErrDecrypt, err)
+}
+
+func TestVerifyRequireASCIIDomain(t *testing.T) {
+	opts := VerifyOptions{RequireASCIIDomain: true}
+
+	testVerifyOptions(t, []verifyOptionsCase{
+		{"ascii", nil, opts, nil},
+		{"punycode", map[string]interface{}{"domain": "xn--80ak6aa92e.com"}, opts, nil},
+		{"homoglyph", map[string]interface{}{"domain": "exаmple.com"}, opts, &InvalidMessage{"Domain must only contain ASCII characters, use punycode for internationalized domains"}},
+	})
+
+	for _, c := range []struct {
+		domain string
+		issues []LintIssue
+	}{
+		{"xn--80ak6aa92e.com", nil},
+		{"exаmple.com", []LintIssue{{1, "domain", "contains non-ASCII characters, use punycode for internationalized domains"}}},
+	} {
+		message, _ := signedMessage(t, map[string]interface{}{"domain": c.domain})
+		assert.Equal(t, c.issues, Lint(message.String()), c.domain)
+	}
+}
+
+func TestGetValueGetters(t *testing.T) {
+	empty, err := InitMessage(domain, addressStr, uri, nonce, map[string]interface{}{})
+	assert.Nil(t, err)
+	assert.Equal(t, "", empty.GetSchemeValue())
+	assert.Equal(t, "", empty.GetStatementValue())
+	assert.Equal(t, "", empty.GetExpirationTimeValue())
+	assert.Equal(t, "", empty.GetNotBeforeValue())
+	assert.Equal(t, "", empty.GetRequestIDValue())
+
+	assert.Equal(t, "", (&Message{}).GetStatementValue())
+
+	assert.Equal(t, statement, message.GetStatementValue())
+	assert.Equal(t, *message.GetExpirationTime(), message.GetExpirationTimeValue())
+	assert.Equal(t, *message.GetNotBefore(), message.GetNotBeforeValue())
+	assert.Equal(t, requestId, message.GetRequestIDValue())
+}
+
+func TestVerifyRequireCanonicalSignature(t *testing.T) {
+	message, err := ParseMessage(walletMessage)
+	assert.Nil(t, err)
+	opts := VerifyOptions{RequireCanonicalSignature: true}
+	timestamp := time.Date(2022, 12, 1, 12, 0, 0, 0, time.UTC)
+	opts.Timestamp = &timestamp
+
+	_, err = message.VerifyWithOptions(walletSignature, opts)
+	assert.Nil(t, err)
+
+	sig, err := hexutil.Decode(walletSignature)
+	assert.Nil(t, err)
+	s := new(big.Int).SetBytes(sig[32:64])
+	highS := new(big.Int).Sub(crypto.S256().Params().N, s)
+	highS.FillBytes(sig[32:64])
+	sig[64] = 55 - sig[64]
+	malleable := hexutil.Encode(sig)
+
+	_, err = message.VerifyWithOptions(malleable, VerifyOptions{Timestamp: &timestamp})
+	assert.Nil(t, err, "high-S signatures recover to the same signer")
+
+	_, err = message.VerifyWithOptions(malleable, opts)
+	assert.Equal(t, &InvalidSignature{"Signature is malleable, S must be in the lower half of the curve order"}, err)
+
+	_, err = message.VerifyWithOptions(walletSignature[:len(walletSignature)-2], opts)
+	assert.Equal(t, &InvalidSignature{"Signature must be 65 bytes long"}, err)
+}
+
+func BenchmarkParseMinimalMessage(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := ParseMessage(walletMessage); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseFullMessage(b *testing.B) {
+	full := message.String()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ParseMessage(full); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestMinimalGrammarMatchesFullGrammar(t *testing.T) {
+	full := func(message string) map[string]string {
+		match := _SIWE_MESSAGE.FindStringSubmatch(message)
+		if match == nil {
+			return nil
+		}
+		fields := make(map[string]string)
+		for i, name := range _SIWE_MESSAGE.SubexpNames() {
+			if i != 0 && name != "" && match[i] != "" {
+				fields[name] = match[i]
+			}
+		}
+		return fields
+	}
+
+	for _, msg := range []string{
+		walletMessage,
+		strings.Replace(walletMessage, "Sign in with Ethereum to the app.\n\n", "\n", 1),
+		strings.Replace(walletMessage, "Nonce: k7bNPyc9Y2H8rZbT", "Nonce: short", 1),
+		walletMessage + "\n",
+		"https://" + walletMessage,
+	} {
+		assert.Equal(t, _SIWE_MINIMAL_MESSAGE, grammarFor(msg))
+		fields, ok := MatchFields(msg)
+		assert.Equal(t, full(msg), fields, msg)
+		assert.Equal(t, fields != nil, ok)
+	}
+
+	assert.Equal(t, _SIWE_MESSAGE, grammarFor(message.String()))
+}
+
+func TestNewMessage(t *testing.T) {
+	built, err := NewMessage(domain, addressStr, uri, version, MessageOptions{
+		Statement: statement,
+		ChainID:   10,
+		Nonce:     nonce,
+		IssuedAt:  issuedAt,
+		RequestID: requestId,
+		Resources: resources,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 10, built.GetChainID())
+	assert.Equal(t, nonce, built.GetNonce())
+	assert.Equal(t, issuedAt, built.GetIssuedAt())
+	assert.Nil(t, built.Validate())
+
+	generated, err := NewMessage(domain, addressStr, uri, "", MessageOptions{})
+	assert.Nil(t, err)
+	assert.True(t, _SIWE_NONCE_VALUE.MatchString(generated.GetNonce()))
+	assert.Equal(t, Version, generated.GetVersion())
+
+	for _, c := range []struct {
+		address string
+		uri     string
+		version string
+		opts    MessageOptions
+		err     error
+	}{
+		{"0x71c7656EC7ab88b098defB751B7401B5f6d8976F", uri, version, MessageOptions{}, &MalformedMessage{"Address has an invalid EIP-55 checksum"}},
+		{addressStr, "not a uri", version, MessageOptions{}, &MalformedMessage{"Invalid format for field `uri`"}},
+		{addressStr, uri, "2", MessageOptions{}, &InvalidVersion{"Unsupported message version `2`"}},
+		{addressStr, uri, version, MessageOptions{ChainID: -1}, &MalformedMessage{"`chainId` must be a positive integer"}},
+		{addressStr, uri, version, MessageOptions{Nonce: "short"}, &MalformedMessage{"`nonce` must be at least 8 alphanumeric characters"}},
+		{addressStr, uri, version, MessageOptions{ExpirationTime: "tomorrow"}, &MalformedMessage{"Invalid format for field `expirationTime`"}},
+	} {
+		_, err := NewMessage(domain, c.address, c.uri, c.version, c.opts)
+		assert.Equal(t, c.err, err)
+	}
+}
+
+func TestStatementContainingURI(t *testing.T) {
+	for _, s := range []string{
+		"Sign in to access https://app.example.com",
+		"URI: https://evil.com",
+		"See https://example.com/terms?a=1#section and http://localhost:3000",
+	} {
+		withURI, err := InitMessage(domain, addressStr, uri, nonce, map[string]interface{}{
+			"statement": s,
+		})
+		assert.Nil(t, err)
+
+		parsed, err := ParseMessage(withURI.String())
+		assert.Nil(t, err)
+		assert.Equal(t, s, *parsed.GetStatement())
+		parsedURI := parsed.GetURI()
+		assert.Equal(t, uri, parsedURI.String())
+		assert.Equal(t, withURI.String(), parsed.String())
+	}
+}
+
+func TestFormatPersonalSignRequest(t *testing.T) {
+	parsed, err := ParseMessage(walletMessage)
+	assert.Nil(t, err)
+
+	params := parsed.FormatPersonalSignRequest()
+	assert.Len(t, params, 2)
+
+	decoded, err := hexutil.Decode(params[0].(string))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte(walletMessage), decoded)
+	assert.Equal(t, walletAddress, params[1])
+
+	encoded, err := json.Marshal(params)
+	assert.Nil(t, err)
+	assert.Equal(t, fmt.Sprintf("[%q,%q]", hexutil.Encode([]byte(walletMessage)), walletAddress), string(encoded))
+}
+
+func TestVerifyAllowedChainIDs(t *testing.T) {
+	polygon := map[string]interface{}{"chainId": 137}
+
+	testVerifyOptions(t, []verifyOptionsCase{
+		{"allowed", polygon, VerifyOptions{AllowedChainIDs: []int{1, 137}}, nil},
+		{"not allowed", polygon, VerifyOptions{AllowedChainIDs: []int{1, 10}}, &InvalidMessage{"Chain ID 137 is not allowed"}},
+		{"empty allowlist", polygon, VerifyOptions{AllowedChainIDs: []int{}}, nil},
+	})
+}
+
+func TestSetNonce(t *testing.T) {
+	template := message.Clone()
+
+	fresh := MustGenerateNonce()
+	assert.Nil(t, template.SetNonce(fresh))
+	assert.Equal(t, fresh, template.GetNonce())
+	assert.Contains(t, template.String(), "\nNonce: "+fresh+"\n")
+
+	parsed, err := ParseMessage(template.String())
+	assert.Nil(t, err)
+	assert.Equal(t, fresh, parsed.GetNonce())
+
+	assert.Equal(t, &MalformedMessage{"`nonce` must be at least 8 alphanumeric characters"}, template.SetNonce("short"))
+	assert.Equal(t, &MalformedMessage{"`nonce` must be at least 8 alphanumeric characters"}, template.SetNonce("not-alphanumeric"))
+	assert.Equal(t, fresh, template.GetNonce())
+}
+
+func TestWithFreshTimestamps(t *testing.T) {
+	template, err := InitMessage(domain, addressStr, uri, nonce, map[string]interface{}{
+		"issuedAt": "2020-01-01T00:00:00Z",
+	})
+	assert.Nil(t, err)
+
+	fresh := template.WithFreshTimestamps(10 * time.Minute)
+	assert.Equal(t, "2020-01-01T00:00:00Z", template.GetIssuedAt())
+	assert.Nil(t, template.GetExpirationTime())
+
+	issued, _, err := fresh.ParseIssuedAt()
+	assert.Nil(t, err)
+	assert.WithinDuration(t, time.Now(), issued, 5*time.Second)
+
+	expires, ok, err := fresh.ParseExpirationTime()
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 10*time.Minute, expires.Sub(issued))
+
+	parsed, err := ParseMessage(fresh.String())
+	assert.Nil(t, err)
+	assert.True(t, parsed.Equal(fresh))
+
+	assert.Nil(t, fresh.WithFreshTimestamps(0).GetExpirationTime())
+}
+
+func TestParseMissingRequiredGroups(t *testing.T) {
+	emptyURI := strings.Replace(walletMessage, "URI: http://localhost:3000/login", "URI: ", 1)
+	fields, ok := MatchFields(emptyURI)
+	assert.True(t, ok, "the grammar accepts an empty URI")
+	assert.NotContains(t, fields, "uri")
+
+	_, err := ParseMessage(emptyURI)
+	assert.Equal(t, &MalformedMessage{"`uri` must not be empty"}, err)
+
+	_, err = requiredField(map[string]interface{}{}, "nonce")
+	assert.Equal(t, &MalformedMessage{"`nonce` must not be empty"}, err)
+
+	_, err = requiredField(map[string]interface{}{"domain": 1}, "domain")
+	assert.Equal(t, &MalformedMessage{"`domain` must not be empty"}, err)
+}
+
+func TestVerifyRequireNotBefore(t *testing.T) {
+	opts := VerifyOptions{RequireNotBefore: true}
+
+	testVerifyOptions(t, []verifyOptionsCase{
+		{"not before", map[string]interface{}{"notBefore": time.Now().Add(-time.Minute)}, opts, nil},
+		{"no not before", nil, opts, &InvalidMessage{"Message must have a not before time"}},
+		{"not required", nil, VerifyOptions{}, nil},
+	})
+}
+
+// referenceVectors mirror the parsing vectors of the spruceid/siwe reference
+// implementation, for when the siwe-js submodule isn't checked out.
+var referenceVectors = []struct {
+	name    string
+	message string
+	fields  map[string]string
+}{
+	{
+		"couple of optional fields",
+		"service.org wants you to sign in with your Ethereum account:\n0xe5A12547fe4E872D192E3eCecb76F2Ce1aeA4946\n\nI accept the ServiceOrg Terms of Service: https://service.org/tos\n\nURI: https://service.org/login\nVersion: 1\nChain ID: 1\nNonce: 32891757\nIssued At: 2021-09-30T16:25:24.000Z\nResources:\n- ipfs://Qme7ss3ARVgxv6rXqVPiikMJ8u2NLgmgszg13pYrDKEoiu\n- https://example.com/my-web2-claim.json",
+		map[string]string{"domain": "service.org", "statement": "I accept the ServiceOrg Terms of Service: https://service.org/tos", "nonce": "32891757", "issuedAt": "2021-09-30T16:25:24.000Z"},
+	},
+	{
+		"no optional field",
+		"service.org wants you to sign in with your Ethereum account:\n0xe5A12547fe4E872D192E3eCecb76F2Ce1aeA4946\n\nI accept the ServiceOrg Terms of Service: https://service.org/tos\n\nURI: https://service.org/login\nVersion: 1\nChain ID: 1\nNonce: 32891757\nIssued At: 2021-09-30T16:25:24.000Z",
+		map[string]string{"domain": "service.org", "uri": "https://service.org/login"},
+	},
+	{
+		"timestamp without microseconds",
+		"service.org wants you to sign in with your Ethereum account:\n0xe5A12547fe4E872D192E3eCecb76F2Ce1aeA4946\n\nI accept the ServiceOrg Terms of Service: https://service.org/tos\n\nURI: https://service.org/login\nVersion: 1\nChain ID: 1\nNonce: 32891757\nIssued At: 2021-09-30T16:25:24Z",
+		map[string]string{"issuedAt": "2021-09-30T16:25:24Z"},
+	},
+	{
+		"domain is RFC 3986 authority with IP",
+		"127.0.0.1 wants you to sign in with your Ethereum account:\n0xe5A12547fe4E872D192E3eCecb76F2Ce1aeA4946\n\nI accept the ServiceOrg Terms of Service: https://service.org/tos\n\nURI: https://service.org/login\nVersion: 1\nChain ID: 1\nNonce: 32891757\nIssued At: 2021-09-30T16:25:24.000Z",
+		map[string]string{"domain": "127.0.0.1"},
+	},
+	{
+		"domain is RFC 3986 authority with userinfo",
+		"test@127.0.0.1 wants you to sign in with your Ethereum account:\n0xe5A12547fe4E872D192E3eCecb76F2Ce1aeA4946\n\nI accept the ServiceOrg Terms of Service: https://service.org/tos\n\nURI: https://service.org/login\nVersion: 1\nChain ID: 1\nNonce: 32891757\nIssued At: 2021-09-30T16:25:24.000Z",
+		map[string]string{"domain": "test@127.0.0.1"},
+	},
+	{
+		"domain is RFC 3986 authority with port",
+		"127.0.0.1:8080 wants you to sign in with your Ethereum account:\n0xe5A12547fe4E872D192E3eCecb76F2Ce1aeA4946\n\nI accept the ServiceOrg Terms of Service: https://service.org/tos\n\nURI: https://service.org/login\nVersion: 1\nChain ID: 1\nNonce: 32891757\nIssued At: 2021-09-30T16:25:24.000Z",
+		map[string]string{"domain": "127.0.0.1:8080"},
+	},
+	{
+		"domain is RFC 3986 authority with userinfo and port",
+		"test@127.0.0.1:8080 wants you to sign in with your Ethereum account:\n0xe5A12547fe4E872D192E3eCecb76F2Ce1aeA4946\n\nI accept the ServiceOrg Terms of Service: https://service.org/tos\n\nURI: https://service.org/login\nVersion: 1\nChain ID: 1\nNonce: 32891757\nIssued At: 2021-09-30T16:25:24.000Z",
+		map[string]string{"domain": "test@127.0.0.1:8080"},
+	},
+	{
+		"no statement",
+		"service.org wants you to sign in with your Ethereum account:\n0xe5A12547fe4E872D192E3eCecb76F2Ce1aeA4946\n\n\nURI: https://service.org/login\nVersion: 1\nChain ID: 1\nNonce: 32891757\nIssued At: 2021-09-30T16:25:24.000Z",
+		map[string]string{"domain": "service.org"},
+	},
+	{
+		"domain with scheme",
+		"https://service.org wants you to sign in with your Ethereum account:\n0xe5A12547fe4E872D192E3eCecb76F2Ce1aeA4946\n\n\nURI: https://service.org/login\nVersion: 1\nChain ID: 1\nNonce: 32891757\nIssued At: 2021-09-30T16:25:24.000Z",
+		map[string]string{"scheme": "https", "domain": "service.org"},
+	},
+	{
+		"all optional fields",
+		"service.org wants you to sign in with your Ethereum account:\n0xe5A12547fe4E872D192E3eCecb76F2Ce1aeA4946\n\nI accept the ServiceOrg Terms of Service: https://service.org/tos\n\nURI: https://service.org/login\nVersion: 1\nChain ID: 1\nNonce: 32891757\nIssued At: 2021-09-30T16:25:24.000Z\nExpiration Time: 2021-10-01T16:25:24.000Z\nNot Before: 2021-09-30T16:25:24.000Z\nRequest ID: 200\nResources:\n- ipfs://Qme7ss3ARVgxv6rXqVPiikMJ8u2NLgmgszg13pYrDKEoiu",
+		map[string]string{"expirationTime": "2021-10-01T16:25:24.000Z", "notBefore": "2021-09-30T16:25:24.000Z", "requestId": "200"},
+	},
+}
+
+func TestReferenceVectors(t *testing.T) {
+	for _, vector := range referenceVectors {
+		t.Run(vector.name, func(t *testing.T) {
+			parsed, err := ParseMessage(vector.message)
+			if !assert.Nil(t, err) {
+				return
+			}
+			assert.Nil(t, parsed.Validate())
+			assert.Equal(t, vector.message, parsed.String())
+
+			fields, ok := MatchFields(vector.message)
+			assert.True(t, ok)
+			for key, value := range vector.fields {
+				assert.Equal(t, value, fields[key], key)
+			}
+		})
+	}
+}
+
+func TestReferenceNegativeVectors(t *testing.T) {
+	base := referenceVectors[1].message
+	for name, message := range map[string]string{
+		"missing mandatory field":  strings.Replace(base, "\nNonce: 32891757", "", 1),
+		"wrong version":            strings.Replace(base, "Version: 1", "Version: 2", 1),
+		"invalid chain id":         strings.Replace(base, "Chain ID: 1", "Chain ID: one", 1),
+		"nonce too short":          strings.Replace(base, "Nonce: 32891757", "Nonce: 1234567", 1),
+		"invalid issued at":        strings.Replace(base, "2021-09-30T16:25:24.000Z", "2021-09-30 16:25:24", 1),
+		"non EIP-55 address":       strings.Replace(base, "0xe5A12547fe4E872D192E3eCecb76F2Ce1aeA4946", "0xe5a12547fe4e872d192e3ececb76f2ce1aea4946", 1),
+		"missing address newlines": strings.Replace(base, "4946\n\n", "4946\n", 1),
+	} {
+		_, err := ParseMessage(message)
+		assert.NotNil(t, err, name)
+	}
+}
+
+func TestPresentFields(t *testing.T) {
+	mixed, err := InitMessage(domain, addressStr, uri, nonce, map[string]interface{}{
+		"statement": statement,
+		"notBefore": notBefore,
+		"resources": resources,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"statement", "notBefore", "resources"}, mixed.PresentFields())
+
+	blank, err := InitMessage(domain, addressStr, uri, nonce, map[string]interface{}{
+		"statement": " ",
+	})
+	assert.Nil(t, err)
+	assert.Empty(t, blank.PresentFields())
+
+	assert.Equal(t, []string{"statement", "expirationTime", "notBefore", "requestId", "resources"}, message.PresentFields())
+}
+
+func TestVerifyAddressCasing(t *testing.T) {
+	privateKey, address := createWallet(t)
+
+	for _, stored := range []string{address, strings.ToLower(address)} {
+		for _, expected := range []string{address, strings.ToLower(address)} {
+			message, err := InitMessage(domain, stored, uri, nonce, map[string]interface{}{})
+			assert.Nil(t, err)
+			signature, err := Sign(message, privateKey)
+			assert.Nil(t, err)
+
+			ok, err := message.VerifySignerAddress(signature, common.HexToAddress(expected))
+			assert.Nil(t, err)
+			assert.True(t, ok, "stored %s, expected %s", stored, expected)
+
+			pkey, err := message.VerifyWithOptions(signature, VerifyOptions{})
+			assert.Nil(t, err)
+			assert.Equal(t, common.HexToAddress(expected), crypto.PubkeyToAddress(*pkey))
+
+			_, err = message.VerifyWithOptions(signature, VerifyOptions{RequireChecksumAddress: true})
+			if stored == address {
+				assert.Nil(t, err)
+			} else {
+				assert.Equal(t, &InvalidMessage{"Address was not provided in EIP-55 format"}, err)
+			}
+		}
+	}
+}
+
+func TestParseMessageReader(t *testing.T) {
+	parsed, err := ParseMessageReader(strings.NewReader(walletMessage))
+	assert.Nil(t, err)
+	expected, err := ParseMessage(walletMessage)
+	assert.Nil(t, err)
+	assert.True(t, expected.Equal(parsed))
+
+	_, err = ParseMessageReader(strings.NewReader("not a siwe message"))
+	assert.Equal(t, &ParseError{"domain"}, err)
+
+	oversized := strings.NewReader(walletMessage + strings.Repeat("x", DefaultParseLimits.MaxMessageBytes))
+	_, err = ParseMessageReader(oversized)
+	assert.Equal(t, &InvalidMessage{fmt.Sprintf("Message exceeds the maximum length of %d bytes", DefaultParseLimits.MaxMessageBytes)}, err)
+	assert.Greater(t, oversized.Len(), 0, "reading must stop at the limit")
+
+	defaults := DefaultParseLimits
+	DefaultParseLimits.MaxMessageBytes = 0
+	defer func() { DefaultParseLimits = defaults }()
+
+	parsed, err = ParseMessageReader(strings.NewReader(walletMessage))
+	assert.Nil(t, err, "a zero limit disables the check")
+	assert.True(t, expected.Equal(parsed))
+}
+
+func TestValidNonce(t *testing.T) {
+	assert.True(t, ValidNonce("k7bNPyc9Y2H8rZbT"))
+	assert.True(t, ValidNonce("12345678"))
+	assert.True(t, ValidNonce(MustGenerateNonce()))
+
+	assert.False(t, ValidNonce(""))
+	assert.False(t, ValidNonce("1234567"))
+	assert.False(t, ValidNonce("nonce-with-dash"))
+	assert.False(t, ValidNonce("nonce with space"))
+	assert.False(t, ValidNonce("abcdefgh\n"))
+}
+
+type countingContractCaller struct {
+	mockContractCaller
+	calls int
+}
+
+func (c *countingContractCaller) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	c.calls++
+	return c.mockContractCaller.CallContract(ctx, call, blockNumber)
+}
+
+func TestVerifyEIP1271Cache(t *testing.T) {
+	message, err := InitMessage(domain, addressStr, uri, nonce, options)
+	assert.Nil(t, err)
+
+	signature := hexutil.Encode([]byte("contract wallet signature"))
+	magic := common.RightPadBytes([]byte{0x16, 0x26, 0xba, 0x7e}, 32)
+
+	now := time.Now()
+	cache := NewMemoryEIP1271Cache(time.Minute)
+	cache.now = func() time.Time { return now }
+
+	caller := &countingContractCaller{mockContractCaller: mockContractCaller{code: []byte{0x60}, output: magic}}
+	opts := VerifyOptions{ContractCaller: caller, EIP1271Cache: cache}
+
+	for i := 0; i < 3; i++ {
+		_, err = message.VerifyWithOptions(signature, opts)
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, 1, caller.calls)
+
+	other := hexutil.Encode([]byte("another signature"))
+	_, err = message.VerifyWithOptions(other, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, caller.calls)
+
+	now = now.Add(2 * time.Minute)
+	_, err = message.VerifyWithOptions(signature, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, caller.calls, "expired entries must call the contract again")
+
+	rejecting := &countingContractCaller{mockContractCaller: mockContractCaller{code: []byte{0x60}, output: make([]byte, 32)}}
+	rejectOpts := VerifyOptions{ContractCaller: rejecting, EIP1271Cache: NewMemoryEIP1271Cache(time.Minute)}
+	for i := 0; i < 2; i++ {
+		_, err = message.VerifyWithOptions(signature, rejectOpts)
+		assert.Equal(t, &InvalidSignature{"Contract wallet rejected signature"}, err)
+	}
+	assert.Equal(t, 2, rejecting.calls, "rejections must not be cached")
+}
+
+func TestChainIDConsistency(t *testing.T) {
+	built, err := InitMessage(domain, addressStr, uri, nonce, map[string]interface{}{
+		"chainId": "137",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 137, built.GetChainID())
+	assert.Contains(t, built.String(), "\nChain ID: 137\n")
+
+	assert.Nil(t, built.SetNonce(MustGenerateNonce()))
+	assert.Equal(t, 137, built.GetChainID())
+	assert.Contains(t, built.String(), "\nChain ID: 137\n")
+
+	encoded, err := json.Marshal(built)
+	assert.Nil(t, err)
+	var decoded Message
+	assert.Nil(t, json.Unmarshal(encoded, &decoded))
+	assert.Equal(t, 137, decoded.GetChainID())
+	assert.Equal(t, built.String(), decoded.String())
+
+	parsed, err := ParseMessage(decoded.String())
+	assert.Nil(t, err)
+	assert.Equal(t, 137, parsed.GetChainID())
+}
+
+func TestExpiresIn(t *testing.T) {
+	future, err := InitMessage(domain, addressStr, uri, nonce, map[string]interface{}{
+		"expirationTime": time.Now().Add(time.Hour),
+	})
+	assert.Nil(t, err)
+	remaining, ok := future.ExpiresIn()
+	assert.True(t, ok)
+	assert.InDelta(t, float64(time.Hour), float64(remaining), float64(5*time.Second))
+
+	past, err := InitMessage(domain, addressStr, uri, nonce, map[string]interface{}{
+		"expirationTime": "2022-01-01T00:00:00Z",
+	})
+	assert.Nil(t, err)
+	remaining, ok = past.ExpiresInAt(time.Date(2022, 1, 1, 0, 10, 0, 0, time.UTC))
+	assert.True(t, ok)
+	assert.Equal(t, -10*time.Minute, remaining)
+
+	absent, err := InitMessage(domain, addressStr, uri, nonce, map[string]interface{}{})
+	assert.Nil(t, err)
+	remaining, ok = absent.ExpiresIn()
+	assert.False(t, ok)
+	assert.Zero(t, remaining)
+}
+
+func TestVerifyRequiredResources(t *testing.T) {
+	granted := map[string]interface{}{"resources": resources}
+	required := func(resources ...string) VerifyOptions {
+		return VerifyOptions{RequiredResources: resources}
+	}
+
+	testVerifyOptions(t, []verifyOptionsCase{
+		{"all granted", granted, required(resourcesStr...), nil},
+		{"missing", granted, required(resourcesStr[0], "https://example.com/resources/3"), &InvalidMessage{"Message is missing required resource `https://example.com/resources/3`"}},
+		{"exact match", granted, required(resourcesStr[0] + "/"), &InvalidMessage{fmt.Sprintf("Message is missing required resource `%s/`", resourcesStr[0])}},
+		{"none required", granted, required(), nil},
+	})
+}
+
+func TestCanonicalize(t *testing.T) {
+	_, address := createWallet(t)
+
+	client, err := InitMessage(domain, strings.ToLower(address), uri, nonce, map[string]interface{}{
+		"statement":      "  Sign in to the app ",
+		"issuedAt":       "2022-12-01T14:00:00.500+02:00",
+		"expirationTime": "2022-12-01T15:00:00+02:00",
+	})
+	assert.Nil(t, err)
+
+	server, err := InitMessage(domain, address, uri, nonce, map[string]interface{}{
+		"statement":      "Sign in to the app",
+		"issuedAt":       "2022-12-01T12:00:00.5Z",
+		"expirationTime": time.Date(2022, 12, 1, 13, 0, 0, 0, time.UTC),
+	})
+	assert.Nil(t, err)
+
+	assert.NotEqual(t, client.String(), server.String())
+
+	canonicalClient, err := client.Canonicalize()
+	assert.Nil(t, err)
+	canonicalServer, err := server.Canonicalize()
+	assert.Nil(t, err)
+
+	assert.Equal(t, canonicalServer.String(), canonicalClient.String())
+	assert.Equal(t, canonicalServer.SignHash(), canonicalClient.SignHash())
+	assert.Equal(t, "2022-12-01T12:00:00.5Z", canonicalClient.GetIssuedAt())
+	assert.Equal(t, "  Sign in to the app ", *client.GetStatement(), "the original is left untouched")
+
+	blank, err := InitMessage(domain, address, uri, nonce, map[string]interface{}{"statement": " "})
+	assert.Nil(t, err)
+	canonicalBlank, err := blank.Canonicalize()
+	assert.Nil(t, err)
+	assert.Nil(t, canonicalBlank.GetStatement())
+}
+
+func TestVerifyAllowedURISchemes(t *testing.T) {
+	didURI := "did:key:z6MkhaXgBZDvotDkL5257faiztiGiC2QtKLGpbnnEGta2doK"
+	at := func(uri string) map[string]interface{} {
+		return map[string]interface{}{"uri": uri}
+	}
+	allowed := func(schemes ...string) VerifyOptions {
+		return VerifyOptions{AllowedURISchemes: schemes}
+	}
+	web := VerifyOptions{AllowedURISchemes: WebURISchemes()}
+
+	testVerifyOptions(t, []verifyOptionsCase{
+		{"https", at("https://example.com/login"), web, nil},
+		{"uppercase", at("HTTP://example.com/login"), web, nil},
+		{"javascript", at("javascript:alert(1)"), web, &InvalidMessage{"URI scheme `javascript` is not allowed"}},
+		{"custom", at("myapp://callback"), allowed("https", "myapp"), nil},
+		{"custom only", at("https://example.com"), allowed("myapp"), &InvalidMessage{"URI scheme `https` is not allowed"}},
+
+		// Only web origins are accepted by default
+		{"default https", at("https://example.com/login"), VerifyOptions{}, nil},
+		{"default javascript", at("javascript:alert(1)"), VerifyOptions{}, &InvalidMessage{"URI scheme `javascript` is not allowed"}},
+		{"default did", at(didURI), VerifyOptions{}, &InvalidMessage{"URI scheme `did` is not allowed"}},
+		{"any scheme", at(didURI), VerifyOptions{AllowAnyURIScheme: true}, nil},
+	})
+
+	// The legacy Verify keeps accepting any scheme
+	didMessage, signature := signedMessage(t, at(didURI))
+	_, err := didMessage.Verify(signature, nil, nil, nil)
+	assert.Nil(t, err)
+
+	// Changing a returned slice doesn't widen the default
+	schemes := WebURISchemes()
+	schemes[0] = "javascript"
+	assert.Equal(t, []string{"http", "https"}, WebURISchemes())
+	testVerifyOptions(t, []verifyOptionsCase{
+		{"default after change", at("javascript:alert(1)"), VerifyOptions{}, &InvalidMessage{"URI scheme `javascript` is not allowed"}},
+	})
+}
@@ -0,0 +1,126 @@
+package siwe
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Line grammars of the EIP-4361 ABNF, each anchored to a whole line.
+var (
+	_STRICT_GREETING   = regexp.MustCompile(fmt.Sprintf("^(%s://)?[^/?#\\s]+ wants you to sign in with your Ethereum account:$", _SIWE_SCHEME))
+	_STRICT_ADDRESS    = regexp.MustCompile("^0x[a-fA-F0-9]{40}$")
+	_STRICT_STATEMENT  = regexp.MustCompile("^[a-zA-Z0-9\\-._~:/?#\\[\\]@!$&'()*+,;= ]+$")
+	_STRICT_URI        = regexp.MustCompile(fmt.Sprintf("^URI: %s$", _RFC3986))
+	_STRICT_VERSION    = regexp.MustCompile("^Version: [0-9]+$")
+	_STRICT_CHAIN_ID   = regexp.MustCompile("^Chain ID: [0-9]+$")
+	_STRICT_NONCE      = regexp.MustCompile(fmt.Sprintf("^Nonce: %s$", _NONCE))
+	_STRICT_ISSUED_AT  = regexp.MustCompile(fmt.Sprintf("^Issued At: %s$", _SIWE_DATETIME))
+	_STRICT_EXPIRATION = regexp.MustCompile(fmt.Sprintf("^Expiration Time: %s$", _SIWE_DATETIME))
+	_STRICT_NOT_BEFORE = regexp.MustCompile(fmt.Sprintf("^Not Before: %s$", _SIWE_DATETIME))
+	_STRICT_REQUEST_ID = regexp.MustCompile("^Request ID: [-._~!$&'()*+,;=:@%a-zA-Z0-9]*$")
+	_STRICT_RESOURCE   = regexp.MustCompile(fmt.Sprintf("^- %s$", _RFC3986))
+)
+
+type strictLine struct {
+	section string
+	pattern *regexp.Regexp
+}
+
+// validateStrict checks message line by line against the EIP-4361 ABNF,
+// rejecting any deviation in field order, blank lines or characters.
+func validateStrict(message string) error {
+	lines := strings.Split(message, "\n")
+	pos := 0
+
+	expect := func(section string, pattern *regexp.Regexp) error {
+		if pos >= len(lines) || !pattern.MatchString(lines[pos]) {
+			return &ParseError{section}
+		}
+		pos++
+		return nil
+	}
+
+	optional := func(pattern *regexp.Regexp) bool {
+		if pos < len(lines) && pattern.MatchString(lines[pos]) {
+			pos++
+			return true
+		}
+		return false
+	}
+
+	blank := func(section string) error {
+		if pos >= len(lines) || lines[pos] != "" {
+			return &ParseError{section}
+		}
+		pos++
+		return nil
+	}
+
+	if err := expect("domain", _STRICT_GREETING); err != nil {
+		return err
+	}
+
+	if err := expect("address", _STRICT_ADDRESS); err != nil {
+		return err
+	}
+
+	if err := blank("address"); err != nil {
+		return err
+	}
+
+	optional(_STRICT_STATEMENT)
+	if err := blank("statement"); err != nil {
+		return err
+	}
+
+	for _, line := range []strictLine{
+		{"uri", _STRICT_URI},
+		{"version", _STRICT_VERSION},
+		{"chainId", _STRICT_CHAIN_ID},
+		{"nonce", _STRICT_NONCE},
+		{"issuedAt", _STRICT_ISSUED_AT},
+	} {
+		if err := expect(line.section, line.pattern); err != nil {
+			return err
+		}
+	}
+
+	optional(_STRICT_EXPIRATION)
+	optional(_STRICT_NOT_BEFORE)
+	optional(_STRICT_REQUEST_ID)
+
+	if pos < len(lines) && lines[pos] == "Resources:" {
+		pos++
+		if err := expect("resources", _STRICT_RESOURCE); err != nil {
+			return err
+		}
+		for optional(_STRICT_RESOURCE) {
+		}
+	}
+
+	if pos != len(lines) {
+		return &ParseError{locateTrailingSection(lines[pos])}
+	}
+
+	return nil
+}
+
+func locateTrailingSection(line string) string {
+	for _, section := range _SIWE_SECTIONS {
+		if section.label != "" && strings.HasPrefix("\n"+line, section.label) {
+			return section.name
+		}
+	}
+	return ""
+}
+
+// ParseMessageStrict returns a Message object by parsing an EIP-4361 formatted
+// string, rejecting anything that deviates from the exact ABNF line structure.
+func ParseMessageStrict(message string) (*Message, error) {
+	if err := validateStrict(message); err != nil {
+		return nil, err
+	}
+
+	return ParseMessage(message)
+}
@@ -1,14 +1,23 @@
 package siwe
 
 import (
+	"crypto/rand"
 	"fmt"
+	"io"
+	"math/big"
 	"strings"
 	"time"
+	"unicode"
 
-	"github.com/dchest/uniuri"
 	"github.com/relvacode/iso8601"
 )
 
+const _NONCE_MIN_LENGTH = 8
+const _NONCE_CHARS = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+// _READABLE_NONCE_CHARS excludes the easily confused 0, O, 1, l and I.
+const _READABLE_NONCE_CHARS = "ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz23456789"
+
 func parseTimestamp(fields map[string]interface{}, key string) (*string, error) {
 	var value string
 
@@ -17,13 +26,17 @@ func parseTimestamp(fields map[string]interface{}, key string) (*string, error)
 		case time.Time:
 			value = val.(time.Time).UTC().Format(time.RFC3339)
 		case string:
+			// The grammar restricts timestamps to RFC 3339, which iso8601 alone doesn't enforce
+			if !_SIWE_DATETIME_VALUE.MatchString(val.(string)) {
+				return nil, &MalformedMessage{fmt.Sprintf("Invalid format for field `%s`", key)}
+			}
 			_, err := iso8601.ParseString(val.(string))
 			if err != nil {
-				return nil, &InvalidMessage{fmt.Sprintf("Invalid format for field `%s`", key)}
+				return nil, &MalformedMessage{fmt.Sprintf("Invalid format for field `%s`", key)}
 			}
 			value = val.(string)
 		default:
-			return nil, &InvalidMessage{fmt.Sprintf("`%s` must be either an ISO8601 formatted string or time.Time", key)}
+			return nil, &MalformedMessage{fmt.Sprintf("`%s` must be either an ISO8601 formatted string or time.Time", key)}
 		}
 	}
 
@@ -34,15 +47,80 @@ func parseTimestamp(fields map[string]interface{}, key string) (*string, error)
 	return &value, nil
 }
 
-func GenerateNonce() string {
-	return uniuri.NewLen(16)
+// randReader is the source of nonce randomness, replaced in tests.
+var randReader io.Reader = rand.Reader
+
+func randomString(length int, chars string) (string, error) {
+	max := big.NewInt(int64(len(chars)))
+	buf := make([]byte, length)
+	for i := range buf {
+		n, err := rand.Int(randReader, max)
+		if err != nil {
+			return "", err
+		}
+		buf[i] = chars[n.Int64()]
+	}
+	return string(buf), nil
+}
+
+// GenerateNonceN returns an alphanumeric nonce of the given length drawn from crypto/rand.
+// Lengths below the EIP-4361 minimum of 8 characters are rejected.
+func GenerateNonceN(length int) (string, error) {
+	if length < _NONCE_MIN_LENGTH {
+		return "", &InvalidMessage{fmt.Sprintf("`nonce` must be at least %d characters long", _NONCE_MIN_LENGTH)}
+	}
+	return randomString(length, _NONCE_CHARS)
+}
+
+// ValidNonce reports whether nonce is a syntactically valid EIP-4361 nonce,
+// i.e. at least 8 alphanumeric characters.
+func ValidNonce(nonce string) bool {
+	return _SIWE_NONCE_VALUE.MatchString(nonce)
+}
+
+// GenerateReadableNonce is like GenerateNonceN, but avoids visually ambiguous
+// characters for nonces that may be read aloud or typed by hand.
+func GenerateReadableNonce(length int) (string, error) {
+	if length < _NONCE_MIN_LENGTH {
+		return "", &InvalidMessage{fmt.Sprintf("`nonce` must be at least %d characters long", _NONCE_MIN_LENGTH)}
+	}
+	return randomString(length, _READABLE_NONCE_CHARS)
+}
+
+// GenerateNonce returns a 16 character alphanumeric nonce drawn from crypto/rand,
+// or an error if the system's secure random source fails.
+func GenerateNonce() (string, error) {
+	return GenerateNonceN(16)
+}
+
+// MustGenerateNonce is like GenerateNonce, but panics if the secure random source fails.
+func MustGenerateNonce() string {
+	nonce, err := GenerateNonce()
+	if err != nil {
+		panic(fmt.Sprintf("siwe: failed to generate nonce: %v", err))
+	}
+	return nonce
+}
+
+// valueOrEmpty returns the value of str, or an empty string if it's nil.
+func valueOrEmpty(str *string) string {
+	if str == nil {
+		return ""
+	}
+	return *str
 }
 
-func isNotEmpty(str *string) bool {
-	return str != nil && len(strings.TrimSpace(*str)) > 0
+func isASCII(str string) bool {
+	for i := 0; i < len(str); i++ {
+		if str[i] > unicode.MaxASCII {
+			return false
+		}
+	}
+	return true
 }
 
-func isEmpty(str *string) bool {
+// isBlank reports whether str is nil or only holds whitespace, it never dereferences a nil pointer.
+func isBlank(str *string) bool {
 	return str == nil || len(strings.TrimSpace(*str)) == 0
 }
 